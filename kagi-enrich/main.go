@@ -7,10 +7,13 @@ import (
 	"fmt"
 	"html"
 	"io"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -32,6 +35,8 @@ type apiMeta struct {
 	Node       string   `json:"node,omitempty"`
 	MS         int      `json:"ms,omitempty"`
 	APIBalance *float64 `json:"api_balance,omitempty"`
+	Attempts   int      `json:"attempts,omitempty"`   // API attempts, including retries
+	LatencyMS  int64    `json:"latency_ms,omitempty"` // total call time, including backoff
 }
 
 type apiItem struct {
@@ -73,7 +78,7 @@ func main() {
 	args := os.Args[1:]
 	if len(args) == 0 {
 		printGeneralUsage()
-		os.Exit(1)
+		os.Exit(exitCodes[kindUsage])
 	}
 
 	var err error
@@ -94,8 +99,7 @@ func main() {
 	}
 
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+		exitWithError(err, slices.Contains(args, "--json"))
 	}
 }
 
@@ -144,12 +148,12 @@ func runEnrich(index string, args []string) error {
 			i = len(args)
 		case "-n":
 			if i+1 >= len(args) {
-				return errors.New("missing value for -n")
+				return usageError(errors.New("missing value for -n"), nil)
 			}
 			i++
 			n, err := strconv.Atoi(args[i])
 			if err != nil || n < 1 {
-				return fmt.Errorf("invalid value for -n: %s", args[i])
+				return usageError(fmt.Errorf("invalid value for -n: %s", args[i]), nil)
 			}
 			limit = n
 		case flagJSON:
@@ -158,17 +162,17 @@ func runEnrich(index string, args []string) error {
 			showBalance = true
 		case "--timeout":
 			if i+1 >= len(args) {
-				return errors.New("missing value for --timeout")
+				return usageError(errors.New("missing value for --timeout"), nil)
 			}
 			i++
 			n, err := strconv.Atoi(args[i])
 			if err != nil || n < 1 {
-				return fmt.Errorf("invalid value for --timeout: %s", args[i])
+				return usageError(fmt.Errorf("invalid value for --timeout: %s", args[i]), nil)
 			}
 			timeoutSec = n
 		default:
 			if strings.HasPrefix(arg, "-") {
-				return fmt.Errorf("unknown option: %s", arg)
+				return usageError(fmt.Errorf("unknown option: %s", arg), nil)
 			}
 			queryParts = append(queryParts, arg)
 		}
@@ -182,7 +186,7 @@ func runEnrich(index string, args []string) error {
 
 	apiKey := strings.TrimSpace(os.Getenv("KAGI_API_KEY"))
 	if apiKey == "" {
-		return errors.New("KAGI_API_KEY environment variable is required (https://kagi.com/settings/api)")
+		return missingKeyError()
 	}
 
 	endpoint := enrichWebURL
@@ -277,7 +281,7 @@ func runBalance(args []string) error {
 		case flagJSON:
 			jsonOut = true
 		default:
-			return fmt.Errorf("unknown option: %s", args[i])
+			return usageError(fmt.Errorf("unknown option: %s", args[i]), nil)
 		}
 	}
 
@@ -314,47 +318,321 @@ func fetchEnrich(client *http.Client, apiKey, endpoint, query string) (*enrichRe
 	params := url.Values{}
 	params.Set("q", query)
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	status, body, call, err := doAPIRequest(client, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, endpoint+"?"+params.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bot "+apiKey)
+		req.Header.Set("Accept", "application/json")
+		return req, nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, call.annotate(err)
 	}
-	req.Header.Set("Authorization", "Bot "+apiKey)
-	req.Header.Set("Accept", "application/json")
 
+	if status < 200 || status >= 300 {
+		return nil, call.annotate(apiError(status, body))
+	}
+
+	var out enrichResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	out.Meta.Attempts = call.attempts
+	out.Meta.LatencyMS = call.latency.Milliseconds()
+	return &out, nil
+}
+
+// Error kinds, reported as "kind" in JSON errors. Each has its own exit code.
+const (
+	kindInternal  = "internal"
+	kindUsage     = "usage"
+	kindAuth      = "auth"
+	kindBalance   = "balance"
+	kindRateLimit = "rate_limit"
+	kindNetwork   = "network"
+	kindAPI       = "api"
+)
+
+// exitCodes are the documented process exit codes of each error kind. They
+// are the same in all kagi-* tools; kagi-search also uses 8 (blocked) and 9
+// (fetch).
+var exitCodes = map[string]int{
+	kindInternal:  1,
+	kindUsage:     2,
+	kindAuth:      3,
+	kindBalance:   4,
+	kindRateLimit: 5,
+	kindNetwork:   6,
+	kindAPI:       7,
+}
+
+// cliError is an error with a kind, reported by main with the kind's exit
+// code and, with --json, as a structured error object.
+type cliError struct {
+	kind      string
+	status    int // HTTP status, for API errors
+	apiCode   int // Kagi error[].code, when the API sent one
+	retryable bool
+	usage     func() // printed before usage errors in text mode
+	err       error
+}
+
+func (e *cliError) Error() string { return e.err.Error() }
+func (e *cliError) Unwrap() error { return e.err }
+
+// errorOutput is the --json form of a failed command.
+type errorOutput struct {
+	Error errorInfo `json:"error"`
+}
+
+type errorInfo struct {
+	Code      int    `json:"code"` // exit code
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	Status    int    `json:"status,omitempty"`
+	APICode   int    `json:"api_code,omitempty"`
+}
+
+func kindError(kind string, err error) error {
+	return &cliError{kind: kind, err: err}
+}
+
+// usageError marks err as caused by invalid arguments; usage, if not nil,
+// prints the command's usage text.
+func usageError(err error, usage func()) error {
+	return &cliError{kind: kindUsage, usage: usage, err: err}
+}
+
+// missingKeyError is returned when KAGI_API_KEY is not set.
+func missingKeyError() error {
+	return kindError(kindAuth, errors.New("KAGI_API_KEY environment variable is required (https://kagi.com/settings/api)"))
+}
+
+// apiErrorKinds maps Kagi error[].code values to error kinds.
+var apiErrorKinds = map[int]string{
+	2:   kindAuth,    // missing or invalid API token
+	101: kindBalance, // insufficient credit
+}
+
+// apiError classifies a non-2xx Kagi API response. A known error[].code in the
+// body decides the kind; without one, the HTTP status and message are used.
+func apiError(status int, body []byte) error {
+	var errResp struct {
+		Error []struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		} `json:"error"`
+	}
+	e := &cliError{kind: kindAPI, status: status}
+	msg := strings.TrimSpace(string(body))
+	hasCode := false
+	if json.Unmarshal(body, &errResp) == nil && len(errResp.Error) > 0 {
+		e.apiCode = errResp.Error[0].Code
+		msg = errResp.Error[0].Msg
+		hasCode = true
+	} else if len(msg) > 500 {
+		msg = msg[:500] + "..."
+	}
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	e.err = fmt.Errorf("HTTP %d: %s", status, msg)
+
+	lower := strings.ToLower(msg)
+	kind, known := apiErrorKinds[e.apiCode]
+	switch {
+	case hasCode && known:
+		e.kind = kind
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		e.kind = kindAuth
+	case status == http.StatusPaymentRequired || (!hasCode && (strings.Contains(lower, "insufficient") ||
+		strings.Contains(lower, "balance") || strings.Contains(lower, "credit"))):
+		e.kind = kindBalance
+	case status == http.StatusTooManyRequests:
+		e.kind = kindRateLimit
+	}
+	e.retryable = e.kind == kindRateLimit || (e.kind == kindAPI && status >= 500)
+	return e
+}
+
+// classifyError returns the cliError describing err. Errors without a kind
+// are network errors if they come from the transport, else internal.
+func classifyError(err error) *cliError {
+	var ce *cliError
+	if errors.As(err, &ce) {
+		return ce
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &cliError{kind: kindNetwork, retryable: true, err: err}
+	}
+	return &cliError{kind: kindInternal, err: err}
+}
+
+// exitWithError reports err and exits with its kind's exit code. With
+// jsonOut the error is written to stdout as an errorOutput object; otherwise
+// it goes to stderr, after the usage text for usage errors.
+func exitWithError(err error, jsonOut bool) {
+	e := classifyError(err)
+	code := exitCodes[e.kind]
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(errorOutput{Error: errorInfo{
+			Code:      code,
+			Kind:      e.kind,
+			Message:   err.Error(),
+			Retryable: e.retryable,
+			Status:    e.status,
+			APICode:   e.apiCode,
+		}})
+	} else {
+		if e.usage != nil {
+			e.usage()
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	os.Exit(code)
+}
+
+// Retry settings for Kagi API calls, overridable with KAGI_API_RETRIES and
+// KAGI_API_RETRY_BASE_MS.
+const (
+	defaultAPIRetries   = 2
+	defaultAPIRetryBase = 500 * time.Millisecond
+	maxAPIRetryDelay    = 30 * time.Second
+	maxAPIRetries       = 10
+)
+
+// apiCall records how many attempts an API call took and its total latency,
+// including backoff.
+type apiCall struct {
+	attempts int
+	latency  time.Duration
+}
+
+// annotate adds the attempt count to errors of calls that were retried.
+func (c apiCall) annotate(err error) error {
+	if c.attempts > 1 {
+		return fmt.Errorf("%w (after %d attempts)", err, c.attempts)
+	}
+	return err
+}
+
+// doAPIRequest sends the request built by newReq, retrying connection errors
+// and 429, 500, 502, 503 and 504 responses with exponential backoff and
+// jitter, or after Retry-After when the server sends one. Other statuses,
+// including auth and balance errors, are returned from the first attempt.
+// Non-idempotent requests are only retried after errors that happened before
+// the request was sent, so a dropped connection cannot bill a call twice. It
+// returns the final status code and body.
+func doAPIRequest(client *http.Client, newReq func() (*http.Request, error)) (status int, body []byte, call apiCall, err error) {
+	retries := min(envInt("KAGI_API_RETRIES", defaultAPIRetries), maxAPIRetries)
+	base := time.Duration(envInt("KAGI_API_RETRY_BASE_MS", int(defaultAPIRetryBase/time.Millisecond))) * time.Millisecond
+	start := time.Now()
+	defer func() { call.latency = time.Since(start) }()
+
+	for {
+		call.attempts++
+		req, err := newReq()
+		if err != nil {
+			return 0, nil, call, err
+		}
+		var header http.Header
+		status, body, header, err = sendAPIRequest(client, req)
+		if call.attempts > retries || !retryableAPIError(req.Method, status, err) {
+			return status, body, call, err
+		}
+		delay, ok := parseRetryAfter(header.Get("Retry-After"))
+		if !ok {
+			delay = backoffDelay(base, call.attempts)
+		}
+		if delay > maxAPIRetryDelay {
+			return status, body, call, err
+		}
+		time.Sleep(delay)
+	}
+}
+
+func sendAPIRequest(client *http.Client, req *http.Request) (int, []byte, http.Header, error) {
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return 0, nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
 	if err != nil {
-		return nil, err
+		return 0, nil, resp.Header, err
 	}
+	return resp.StatusCode, body, resp.Header, nil
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		var errResp struct {
-			Error []struct {
-				Code int    `json:"code"`
-				Msg  string `json:"msg"`
-			} `json:"error"`
-		}
-		if json.Unmarshal(body, &errResp) == nil && len(errResp.Error) > 0 {
-			return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error[0].Msg)
-		}
-		text := strings.TrimSpace(string(body))
-		if len(text) > 500 {
-			text = text[:500] + "..."
+func retryableAPIError(method string, status int, err error) bool {
+	if err != nil {
+		// A timed-out attempt already used the whole timeout; retrying would
+		// multiply it.
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return false
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, text)
+		return method == http.MethodGet || method == http.MethodHead || requestNotSent(err)
 	}
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
 
-	var out enrichResponse
-	if err := json.Unmarshal(body, &out); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+// backoffDelay returns base·2^(attempt-1), capped at maxAPIRetryDelay, with
+// its upper half randomized so concurrent callers spread out.
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	d := maxAPIRetryDelay
+	// Check before shifting: base<<(attempt-1) overflows for large attempts.
+	if attempt <= 30 && base <= maxAPIRetryDelay>>(attempt-1) {
+		d = base << (attempt - 1)
 	}
+	return d/2 + rand.N(d/2+1)
+}
 
-	return &out, nil
+// requestNotSent reports whether err happened before the request reached the
+// server: a failed DNS lookup or dial, including a refused connection.
+func requestNotSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP
+// date.
+func parseRetryAfter(header string) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// envInt returns the non-negative integer in the environment variable name,
+// or fallback when it is unset or invalid.
+func envInt(name string, fallback int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+	if err != nil || n < 0 {
+		return fallback
+	}
+	return n
 }
 
 func saveBalanceCache(meta apiMeta, source string) error {
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchQuery is one line of batch input. Plain-text lines become a query with
+// default options; lines starting with "{" are decoded as JSON.
+type batchQuery struct {
+	ID      string `json:"id,omitempty"`
+	Query   string `json:"query"`
+	Limit   int    `json:"limit,omitempty"`
+	Content *bool  `json:"content,omitempty"`
+	queryFilters
+}
+
+// batchRecord is one NDJSON output line: a searchOutput tagged with the input
+// line it came from, or an error for that line.
+type batchRecord struct {
+	Line int    `json:"line"`
+	ID   string `json:"id,omitempty"`
+	searchOutput
+	Error *errorInfo `json:"error,omitempty"`
+}
+
+// batchOptions are the search options applied to every query, parsed by
+// parseSearchArgs like those of search. --concurrency sets how many queries
+// run in parallel.
+type batchOptions struct {
+	searchOptions
+	apiKey string
+	robots *robotsPolicy
+}
+
+func runBatch(args []string) error {
+	search, help, err := parseSearchArgs(args)
+	if help {
+		printBatchUsage()
+		return nil
+	}
+	if err != nil {
+		return usageError(err, printBatchUsage)
+	}
+	// Batch output is always NDJSON; --json is accepted for symmetry with search.
+	if len(search.positionals) > 1 {
+		return usageError(errors.New("batch accepts at most one input file"), printBatchUsage)
+	}
+
+	apiKey := strings.TrimSpace(os.Getenv("KAGI_API_KEY"))
+	if apiKey == "" {
+		return missingKeyError()
+	}
+
+	var input io.Reader = os.Stdin
+	if len(search.positionals) == 1 && search.positionals[0] != "-" {
+		f, err := os.Open(search.positionals[0])
+		if err != nil {
+			return usageError(err, nil)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	queries, err := readBatchQueries(input)
+	if err != nil {
+		return err
+	}
+	if len(queries) == 0 {
+		return usageError(errors.New("no queries provided"), printBatchUsage)
+	}
+
+	opts := batchOptions{
+		searchOptions: search,
+		apiKey:        apiKey,
+		robots:        newRobotsPolicy(search.respectRobots, botUserAgent()),
+	}
+
+	client := newHTTPClient(time.Duration(opts.timeoutSec) * time.Second)
+	var contentClient *http.Client
+	if opts.fetchContent || hasContentQuery(queries) {
+		contentClient = newSafeContentClient(client.Timeout)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	var mu sync.Mutex
+	failed := 0
+	var firstErr error
+	var balanceMeta apiMeta
+
+	jobs := make(chan batchLine)
+	var wg sync.WaitGroup
+	for range min(opts.concurrency, len(queries)) {
+		wg.Go(func() {
+			for job := range jobs {
+				rec, err := runBatchQuery(client, contentClient, job, opts)
+				mu.Lock()
+				if err != nil {
+					failed++
+					firstErr = cmp.Or(firstErr, err)
+				} else if !rec.Cached && rec.Meta.APIBalance != nil {
+					balanceMeta = rec.Meta
+				}
+				_ = enc.Encode(rec)
+				mu.Unlock()
+			}
+		})
+	}
+	for _, q := range queries {
+		jobs <- q
+	}
+	close(jobs)
+	wg.Wait()
+	_ = saveBalanceCache(balanceMeta, "kagi-search")
+	if opts.showBalance && balanceMeta.APIBalance != nil {
+		fmt.Fprintf(os.Stderr, "[API Balance: $%.4f]\n", *balanceMeta.APIBalance)
+	}
+
+	fmt.Fprintf(os.Stderr, "[batch: %d queries, %d failed]\n", len(queries), failed)
+	if len(queries) > 0 && failed == len(queries) {
+		return allFailedError(len(queries), "queries", firstErr)
+	}
+	return nil
+}
+
+type batchLine struct {
+	line  int
+	query batchQuery
+	err   error
+}
+
+// readBatchQueries reads one query per line, skipping blank lines and lines
+// starting with "#". Malformed JSON lines are kept so they can be reported as
+// error records instead of aborting the batch.
+func readBatchQueries(r io.Reader) ([]batchLine, error) {
+	var out []batchLine
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		bl := batchLine{line: lineNo}
+		if strings.HasPrefix(line, "{") {
+			if err := json.Unmarshal([]byte(line), &bl.query); err != nil {
+				bl.err = fmt.Errorf("invalid JSON: %w", err)
+			}
+		} else {
+			bl.query.Query = line
+		}
+		out = append(out, bl)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading batch input: %w", err)
+	}
+	return out, nil
+}
+
+func hasContentQuery(queries []batchLine) bool {
+	for _, q := range queries {
+		if q.query.Content != nil && *q.query.Content {
+			return true
+		}
+	}
+	return false
+}
+
+// runBatchQuery runs one input line. A failure is returned and also recorded
+// in the record's Error field.
+func runBatchQuery(client, contentClient *http.Client, job batchLine, opts batchOptions) (batchRecord, error) {
+	rec := batchRecord{Line: job.line, ID: job.query.ID}
+	rec.Query = strings.TrimSpace(job.query.Query)
+	fail := func(err error) (batchRecord, error) {
+		rec.Error = newErrorInfo(err)
+		return rec, err
+	}
+	if job.err != nil {
+		return fail(usageError(job.err, nil))
+	}
+	query, err := buildQuery(job.query.Query, opts.filters.with(job.query.queryFilters))
+	if err != nil {
+		return fail(usageError(err, nil))
+	}
+	rec.Query = query
+	if query == "" {
+		return fail(usageError(errors.New("query is required"), nil))
+	}
+
+	limit := opts.limit
+	if job.query.Limit > 0 {
+		limit = min(job.query.Limit, 100)
+	}
+	fetchContent := opts.fetchContent
+	if job.query.Content != nil {
+		fetchContent = *job.query.Content
+	}
+
+	resp, hit, err := fetchSearchCached(client, opts.apiKey, query, limit, opts.cacheTTL, opts.noCache, opts.refresh)
+	if err != nil {
+		return fail(err)
+	}
+
+	rec.searchOutput = newSearchOutput(query, resp)
+	if hit != nil {
+		rec.Cached = true
+		rec.CachedAt = hit.StoredAt.Format(time.RFC3339)
+	}
+
+	rec.Results = dedupeResults(rec.Results)
+	if fetchContent && contentClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(opts.contentDeadlineSec)*time.Second)
+		fetchResultsContent(ctx, contentClient, rec.Results, fetchOptions{maxChars: opts.maxContentChars, robots: opts.robots, wrapUntrusted: opts.wrapUntrusted}, defaultContentConcurrency)
+		cancel()
+		rec.Results = dedupeResults(rec.Results)
+	}
+	return rec, nil
+}
+
+func printBatchUsage() {
+	fmt.Println("Usage: kagi-search batch [file|-] [-n <num>] [--content] [--concurrency <num>]")
+	fmt.Println()
+	fmt.Println("Reads one query per line from the file (or stdin) and writes one NDJSON")
+	fmt.Println("record per query as it completes. Lines may also be JSON objects:")
+	fmt.Println(`  {"id": "q1", "query": "golang generics", "limit": 5, "content": true, "site": ["go.dev"]}`)
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  -n <num>              Default number of results per query (default: 10, max: 100)")
+	fmt.Println("  --content             Fetch readable page content for every query")
+	fmt.Println("  --site <host>         Restrict every query to a site (repeatable)")
+	fmt.Println("  --exclude-site <host> Exclude a site from every query (repeatable)")
+	fmt.Println("  --filetype <ext>      Restrict every query to a file type (repeatable)")
+	fmt.Println("  --intitle <text>      Require text in the page title (repeatable)")
+	fmt.Printf("  --lens <name>         Preset site group: %s\n", strings.Join(lensNames(), ", "))
+	fmt.Println("  --concurrency <num>   Queries run in parallel (default: 4, max: 16)")
+	fmt.Println("  --timeout <sec>       HTTP timeout in seconds (default: 15)")
+	fmt.Println("  --max-content-chars   Max chars per fetched content (default: 5000)")
+	fmt.Println("  --content-deadline <sec>")
+	fmt.Println("                        Deadline for each query's content fetches (default: 45)")
+	fmt.Println("  --no-cache            Neither read nor write the response cache")
+	fmt.Println("  --refresh             Bypass cached responses but store the new ones")
+	fmt.Println("  --cache-ttl <sec>     Max age of a cached response (default: 3600, 0 disables lookups)")
+	fmt.Println("  --show-balance        Print the last API balance to stderr")
+	fmt.Println("  --respect-robots      Honor robots.txt, X-Robots-Tag and meta robots for --content")
+	fmt.Println("  --wrap-untrusted      Wrap fetched content in <untrusted-content> markers")
+	fmt.Println("  --ignore-robots       Disable robots handling enabled by KAGI_RESPECT_ROBOTS")
+	fmt.Println()
+	fmt.Println("Environment:")
+	fmt.Println("  KAGI_API_KEY          Required. Your Kagi Search API key.")
+	fmt.Println("  KAGI_RESPECT_ROBOTS   Set to 1 to honor robots directives by default")
+	fmt.Println("  KAGI_BOT_USER_AGENT   User-Agent sent when robots handling is enabled")
+}
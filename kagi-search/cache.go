@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSearchCacheTTL = time.Hour
+
+type searchCacheEntry struct {
+	Query    string             `json:"query"`
+	Limit    int                `json:"limit"`
+	StoredAt time.Time          `json:"stored_at"`
+	Response kagiSearchResponse `json:"response"`
+}
+
+type searchCacheStats struct {
+	Dir       string `json:"dir"`
+	Entries   int    `json:"entries"`
+	Expired   int    `json:"expired"`
+	SizeBytes int64  `json:"size_bytes"`
+	Oldest    string `json:"oldest,omitempty"`
+	Newest    string `json:"newest,omitempty"`
+}
+
+// searchCacheTTL returns the cache TTL from KAGI_SEARCH_CACHE_TTL (seconds),
+// falling back to defaultSearchCacheTTL when unset or invalid.
+func searchCacheTTL() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("KAGI_SEARCH_CACHE_TTL"))
+	if raw == "" {
+		return defaultSearchCacheTTL
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return defaultSearchCacheTTL
+	}
+	return time.Duration(n) * time.Second
+}
+
+func searchCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "kagi-skills", "search"), nil
+}
+
+// normalizeCacheQuery folds case and whitespace so trivially different
+// spellings of the same query share a cache entry.
+func normalizeCacheQuery(query string) string {
+	return strings.ToLower(cleanLine(query))
+}
+
+func searchCachePath(query string, limit int) (string, error) {
+	dir, err := searchCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(normalizeCacheQuery(query) + "\x00" + strconv.Itoa(limit)))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// loadSearchCache returns a cached response younger than ttl. A missing,
+// expired or unreadable entry is reported as os.ErrNotExist.
+func loadSearchCache(query string, limit int, ttl time.Duration) (*searchCacheEntry, error) {
+	if ttl <= 0 {
+		return nil, os.ErrNotExist
+	}
+	path, err := searchCachePath(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry searchCacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, os.ErrNotExist
+	}
+	if time.Since(entry.StoredAt) > ttl {
+		return nil, os.ErrNotExist
+	}
+	return &entry, nil
+}
+
+func saveSearchCache(query string, limit int, resp *kagiSearchResponse) error {
+	path, err := searchCachePath(query, limit)
+	if err != nil {
+		return err
+	}
+	entry := searchCacheEntry{
+		Query:    query,
+		Limit:    limit,
+		StoredAt: time.Now().UTC(),
+		Response: *resp,
+	}
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, payload)
+}
+
+// writeFileAtomic writes payload to path, creating its directory. It writes
+// through a temp file so concurrent runs never read a partial entry.
+func writeFileAtomic(path string, payload []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(payload); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// fetchSearchCached serves a search from the on-disk cache when possible and
+// stores fresh responses for later runs. noCache bypasses the cache entirely;
+// refresh skips the lookup but still stores the new response. The returned
+// entry is non-nil only on a cache hit.
+func fetchSearchCached(client *http.Client, apiKey, query string, limit int, ttl time.Duration, noCache, refresh bool) (*kagiSearchResponse, *searchCacheEntry, error) {
+	if !noCache && !refresh {
+		if entry, err := loadSearchCache(query, limit, ttl); err == nil {
+			// Call statistics describe the original request, not this run.
+			entry.Response.Meta.Attempts, entry.Response.Meta.LatencyMS = 0, 0
+			return &entry.Response, entry, nil
+		}
+	}
+
+	resp, err := fetchSearch(client, apiKey, query, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !noCache {
+		_ = saveSearchCache(query, limit, resp)
+	}
+	return resp, nil, nil
+}
+
+func collectSearchCacheStats(ttl time.Duration) (searchCacheStats, error) {
+	dir, err := searchCacheDir()
+	if err != nil {
+		return searchCacheStats{}, err
+	}
+	stats := searchCacheStats{Dir: dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return stats, nil
+		}
+		return stats, err
+	}
+
+	var oldest, newest time.Time
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		stats.Entries++
+		stats.SizeBytes += info.Size()
+		mod := info.ModTime()
+		if ttl > 0 && time.Since(mod) > ttl {
+			stats.Expired++
+		}
+		if oldest.IsZero() || mod.Before(oldest) {
+			oldest = mod
+		}
+		if mod.After(newest) {
+			newest = mod
+		}
+	}
+	if !oldest.IsZero() {
+		stats.Oldest = oldest.UTC().Format(time.RFC3339)
+		stats.Newest = newest.UTC().Format(time.RFC3339)
+	}
+	return stats, nil
+}
+
+func clearSearchCache(expiredOnly bool, ttl time.Duration) (int, error) {
+	dir, err := searchCacheDir()
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if expiredOnly {
+			info, err := e.Info()
+			if err != nil || time.Since(info.ModTime()) <= ttl {
+				continue
+			}
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func runCache(args []string) error {
+	if len(args) == 0 {
+		return usageError(errors.New("cache subcommand is required (stats or clear)"), printCacheUsage)
+	}
+
+	action := args[0]
+	jsonOut := false
+	expiredOnly := false
+	for _, arg := range args[1:] {
+		switch arg {
+		case flagHelpShort, flagHelpLong:
+			printCacheUsage()
+			return nil
+		case flagJSON:
+			jsonOut = true
+		case "--expired":
+			expiredOnly = true
+		default:
+			return usageError(fmt.Errorf("unknown option: %s", arg), printCacheUsage)
+		}
+	}
+
+	ttl := searchCacheTTL()
+	switch action {
+	case flagHelpShort, flagHelpLong:
+		printCacheUsage()
+		return nil
+	case "stats":
+		stats, err := collectSearchCacheStats(ttl)
+		if err != nil {
+			return err
+		}
+		if jsonOut {
+			return writeJSON(stats)
+		}
+		fmt.Printf("Directory: %s\n", stats.Dir)
+		fmt.Printf("Entries: %d (%d expired)\n", stats.Entries, stats.Expired)
+		fmt.Printf("Size: %d bytes\n", stats.SizeBytes)
+		if stats.Oldest != "" {
+			fmt.Printf("Oldest: %s\n", stats.Oldest)
+			fmt.Printf("Newest: %s\n", stats.Newest)
+		}
+		return nil
+	case "clear":
+		removed, err := clearSearchCache(expiredOnly, ttl)
+		if err != nil {
+			return err
+		}
+		if jsonOut {
+			return writeJSON(map[string]int{"removed": removed})
+		}
+		fmt.Printf("Removed %d cached responses\n", removed)
+		return nil
+	default:
+		return usageError(fmt.Errorf("unknown cache subcommand: %s", action), printCacheUsage)
+	}
+}
+
+func printCacheUsage() {
+	fmt.Println("Usage: kagi-search cache <stats|clear> [--json]")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --json                Emit JSON output")
+	fmt.Println("  --expired             With clear: only remove entries older than the TTL")
+	fmt.Println()
+	fmt.Println("Environment:")
+	fmt.Println("  KAGI_SEARCH_CACHE_TTL Cache TTL in seconds (default: 3600)")
+}
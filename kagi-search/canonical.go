@@ -0,0 +1,158 @@
+package main
+
+import (
+	"html"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// trackingParams are query parameters that only carry attribution data and
+// never change the page that is served.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"gbraid":  true,
+	"wbraid":  true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || trackingParams[name]
+}
+
+// canonicalizeURL returns rawURL with a lowercase scheme and host, default
+// ports removed, tracking parameters stripped and the fragment dropped. The
+// remaining query parameters are kept byte for byte, in their order.
+// Unparseable input is returned unchanged.
+func canonicalizeURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 literal
+	}
+	u.Host = host
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if u.RawQuery != "" {
+		// Edit the raw pairs rather than round-tripping through url.Values,
+		// which drops pairs containing ";" and re-escapes the rest.
+		kept := make([]string, 0, strings.Count(u.RawQuery, "&")+1)
+		for pair := range strings.SplitSeq(u.RawQuery, "&") {
+			name, _, _ := strings.Cut(pair, "=")
+			if n, err := url.QueryUnescape(name); err == nil {
+				name = n
+			}
+			if pair != "" && !isTrackingParam(name) {
+				kept = append(kept, pair)
+			}
+		}
+		u.RawQuery = strings.Join(kept, "&")
+	}
+	u.ForceQuery = false
+	return u.String()
+}
+
+// dedupeKey reduces a URL to the parts that identify a page for duplicate
+// detection: scheme, "www." and trailing slashes are ignored on top of the
+// normalization done by canonicalizeURL.
+func dedupeKey(rawURL string) string {
+	u, err := url.Parse(canonicalizeURL(rawURL))
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	host := strings.TrimPrefix(u.Host, "www.")
+	path := strings.TrimRight(u.EscapedPath(), "/")
+	key := host + path
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		sort.Strings(params)
+		key += "?" + strings.Join(params, "&")
+	}
+	return key
+}
+
+// dedupeResults canonicalizes each result's link and collapses results that
+// point at the same page. The best-ranked (first) occurrence is kept; the
+// links of merged duplicates are recorded on it, and any fields it lacks are
+// filled from the duplicates. Fetched content is taken from a duplicate as a
+// whole, including its screening results.
+func dedupeResults(results []searchResult) []searchResult {
+	out := make([]searchResult, 0, len(results))
+	index := make(map[string]int, len(results))
+	for _, r := range results {
+		r.Link = canonicalizeURL(r.Link)
+		keyURL := r.Link
+		if r.CanonicalURL != "" {
+			keyURL = r.CanonicalURL
+		}
+		key := dedupeKey(keyURL)
+
+		i, seen := index[key]
+		if !seen {
+			index[key] = len(out)
+			out = append(out, r)
+			continue
+		}
+
+		kept := &out[i]
+		kept.Duplicates = append(kept.Duplicates, r.Link)
+		kept.Duplicates = append(kept.Duplicates, r.Duplicates...)
+		if kept.Snippet == "" {
+			kept.Snippet = r.Snippet
+		}
+		if kept.Published == "" {
+			kept.Published = r.Published
+		}
+		if kept.Content == "" && r.Content != "" {
+			kept.fetchedContent = r.fetchedContent
+		}
+	}
+	return out
+}
+
+// extractCanonicalURL returns the page's <link rel="canonical"> target
+// resolved against base, or "" if there is none or it is not http(s).
+func extractCanonicalURL(htmlDoc string, base *url.URL) string {
+	for _, tag := range reLinkTag.FindAllString(htmlDoc, -1) {
+		if !reRelCanon.MatchString(tag) {
+			continue
+		}
+		m := reHref.FindStringSubmatch(tag)
+		if m == nil {
+			continue
+		}
+		href := strings.TrimSpace(html.UnescapeString(m[1] + m[2] + m[3]))
+		if href == "" {
+			continue
+		}
+		ref, err := url.Parse(href)
+		if err != nil {
+			continue
+		}
+		if base != nil {
+			ref = base.ResolveReference(ref)
+		}
+		if ref.Scheme != "http" && ref.Scheme != "https" {
+			continue
+		}
+		return canonicalizeURL(ref.String())
+	}
+	return ""
+}
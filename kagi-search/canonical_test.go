@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestCanonicalizeURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"https://Example.COM/Path", "https://example.com/Path"},
+		{"HTTPS://example.com:443/a", "https://example.com/a"},
+		{"http://example.com:80/a", "http://example.com/a"},
+		{"http://example.com:8080/a", "http://example.com:8080/a"},
+		{"https://example.com:80/a", "https://example.com:80/a"},
+		{"https://example.com/a#section", "https://example.com/a"},
+		{"https://example.com/a?utm_source=x&id=7&fbclid=y", "https://example.com/a?id=7"},
+		{"https://example.com/a?UTM_Medium=x", "https://example.com/a"},
+		{"https://example.com/a?b=2&a=1", "https://example.com/a?b=2&a=1"},
+		{"https://example.com/a?x=1;2&q=a%20b", "https://example.com/a?x=1;2&q=a%20b"},
+		{"https://example.com/a%2Fb", "https://example.com/a%2Fb"},
+		{"https://example.com/a?", "https://example.com/a"},
+		{"http://[2001:db8::1]/x", "http://[2001:db8::1]/x"},
+		{"http://[2001:DB8::1]:80/x", "http://[2001:db8::1]/x"},
+		{"https://[2606:4700::1111]:8443/a", "https://[2606:4700::1111]:8443/a"},
+		{"http://192.0.2.1:8080/", "http://192.0.2.1:8080/"},
+		{"not a url", "not a url"},
+		{"/relative/path", "/relative/path"},
+	}
+	for _, tt := range tests {
+		if got := canonicalizeURL(tt.in); got != tt.want {
+			t.Errorf("canonicalizeURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDedupeKey(t *testing.T) {
+	same := [][2]string{
+		{"https://www.example.com/a/", "http://example.com/a"},
+		{"https://example.com/a?b=2&a=1", "https://example.com/a?a=1&b=2&utm_source=x"},
+		{"http://[2001:db8::1]/x", "https://[2001:DB8::1]/x/"},
+	}
+	for _, p := range same {
+		if a, b := dedupeKey(p[0]), dedupeKey(p[1]); a != b {
+			t.Errorf("dedupeKey(%q) = %q, dedupeKey(%q) = %q, want equal", p[0], a, p[1], b)
+		}
+	}
+	if a, b := dedupeKey("http://[2001:db8::1]:8080/x"), dedupeKey("http://[2001:db8::1:8080]/x"); a == b {
+		t.Errorf("IPv6 host with port and a different IPv6 host share key %q", a)
+	}
+}
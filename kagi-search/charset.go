@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/gogs/chardet"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+var (
+	reXMLEncoding = regexp.MustCompile(`^\s*<\?xml[^>]*\sencoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+	reMetaCharset = regexp.MustCompile(`(?i)<meta\s[^>]*charset\s*=`)
+)
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// Charset sniffing looks at the start of the body and ignores weak guesses.
+const (
+	maxSniffBytes      = 64 << 10
+	minSniffConfidence = 30
+)
+
+// decodeBody converts a response body to UTF-8 and returns the name of the
+// charset it was decoded from. Sources are tried in order: byte order mark,
+// the Content-Type charset parameter, <meta charset> / http-equiv and the
+// <?xml encoding?> declaration. Without any of them the charset is sniffed
+// by sniffCharset.
+func decodeBody(body []byte, contentType string) ([]byte, string) {
+	enc, name, certain := charset.DetermineEncoding(body, contentType)
+	if !certain {
+		if xmlEnc, xmlName := xmlDeclEncoding(body); xmlEnc != nil {
+			enc, name, certain = xmlEnc, xmlName, true
+		}
+	}
+	switch {
+	case certain:
+	case !reMetaCharset.Match(body[:min(len(body), 1024)]):
+		// DetermineEncoding's own default is only a guess; sniff instead.
+		enc, name = sniffCharset(body)
+	case utf8.Valid(body):
+		// Declarations found by the <meta> prescan are often wrong; trust
+		// the bytes when they already form valid UTF-8.
+		name = "utf-8"
+	}
+
+	if name == "utf-8" {
+		body = bytes.TrimPrefix(body, utf8BOM)
+		return bytes.ToValidUTF8(body, []byte("�")), name
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return bytes.ToValidUTF8(body, []byte("�")), "utf-8"
+	}
+	return decoded, name
+}
+
+// sniffCharset guesses the charset of an undeclared body. Valid UTF-8 is
+// UTF-8; anything else goes through chardet's statistical detectors (e.g.
+// Shift_JIS, EUC-KR, windows-1251, KOI8-R). windows-1252, the usual encoding
+// of undeclared Western pages and a superset of ISO-8859-1 that maps every
+// byte, is the last resort.
+func sniffCharset(body []byte) (encoding.Encoding, string) {
+	if utf8.Valid(body) {
+		return unicode.UTF8, "utf-8"
+	}
+	sample := body[:min(len(body), maxSniffBytes)]
+	if r, err := chardet.NewHtmlDetector().DetectBest(sample); err == nil && r.Confidence >= minSniffConfidence {
+		if enc, name := charset.Lookup(r.Charset); enc != nil && name != "utf-8" && name != "windows-1252" {
+			return enc, name
+		}
+	}
+	return charmap.Windows1252, "windows-1252"
+}
+
+// xmlDeclEncoding returns the encoding named by an <?xml encoding="..."?>
+// declaration, or nil if there is none or it is unknown.
+func xmlDeclEncoding(body []byte) (encoding.Encoding, string) {
+	m := reXMLEncoding.FindSubmatch(body[:min(len(body), 1024)])
+	if m == nil {
+		return nil, ""
+	}
+	return charset.Lookup(string(m[1]))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestDecodeBody(t *testing.T) {
+	const (
+		japaneseText = "<html><body><p>日本語のウェブページです。文字コードが宣言されていない古いページでも、正しく読めるようにします。東京は日本の首都です。</p></body></html>"
+		russianText  = "<html><body><p>Это русская страница без объявленной кодировки. Москва является столицей России, и текст должен читаться правильно.</p></body></html>"
+		frenchText   = "<html><body><p>Café crème, déjà vu et à la française : une page occidentale sans déclaration.</p></body></html>"
+	)
+	tests := []struct {
+		name        string
+		text        string
+		enc         encoding.Encoding // nil keeps the text as UTF-8
+		contentType string
+		charset     string
+	}{
+		{name: "undeclared UTF-8", text: japaneseText, contentType: "text/html", charset: "utf-8"},
+		{name: "declared in Content-Type", text: russianText, enc: charmap.Windows1251, contentType: "text/html; charset=windows-1251", charset: "windows-1251"},
+		{name: "undeclared Shift_JIS", text: japaneseText, enc: japanese.ShiftJIS, contentType: "text/html", charset: "shift_jis"},
+		{name: "undeclared windows-1251", text: russianText, enc: charmap.Windows1251, contentType: "text/html", charset: "windows-1251"},
+		{name: "undeclared Western", text: frenchText, enc: charmap.Windows1252, contentType: "text/html", charset: "windows-1252"},
+		{name: "meta declaration", text: `<meta charset="shift_jis">` + japaneseText, enc: japanese.ShiftJIS, contentType: "text/html", charset: "shift_jis"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte(tt.text)
+			if tt.enc != nil {
+				var err error
+				if body, err = tt.enc.NewEncoder().Bytes(body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, name := decodeBody(body, tt.contentType)
+			if name != tt.charset {
+				t.Errorf("charset = %q, want %q", name, tt.charset)
+			}
+			if !strings.Contains(string(got), tt.text[len(tt.text)-60:]) {
+				t.Errorf("decoded body = %q", got)
+			}
+		})
+	}
+}
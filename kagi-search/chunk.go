@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const defaultChunkSize = 4000
+
+// contentChunk is one piece of extracted content. Start and End are rune
+// offsets into the full extracted text.
+type contentChunk struct {
+	Index   int    `json:"index"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	Tokens  int    `json:"tokens"`
+	Content string `json:"content"`
+}
+
+// chunkContent splits text into chunks of at most size runes, each starting
+// up to overlap runes before the end of the previous one. Chunks end at the
+// best boundary in their second half: a paragraph or heading, then a line,
+// then a sentence, then a word. The split is deterministic, so --chunk K
+// returns the same chunk across calls.
+func chunkContent(text string, size, overlap int) []contentChunk {
+	r := []rune(text)
+	overlap = min(max(overlap, 0), size/2)
+
+	var chunks []contentChunk
+	start := skipSpace(r, 0)
+	for start < len(r) {
+		end := len(r)
+		if end-start > size {
+			end = chunkBreak(r, start, start+size)
+		}
+
+		trimmedEnd := end
+		for trimmedEnd > start && unicode.IsSpace(r[trimmedEnd-1]) {
+			trimmedEnd--
+		}
+		content := string(r[start:trimmedEnd])
+		chunks = append(chunks, contentChunk{
+			Index:   len(chunks) + 1,
+			Start:   start,
+			End:     trimmedEnd,
+			Tokens:  approxTokens(content),
+			Content: content,
+		})
+		if end >= len(r) {
+			break
+		}
+
+		next := end
+		if overlap > 0 {
+			// Start the overlap at a word boundary.
+			next = end - overlap
+			for next < end && !unicode.IsSpace(r[next-1]) {
+				next++
+			}
+		}
+		start = skipSpace(r, max(next, start+1))
+	}
+	return chunks
+}
+
+// chunkBreak returns the end offset for a chunk starting at start whose hard
+// limit is limit, preferring the strongest boundary in the chunk's second half.
+func chunkBreak(r []rune, start, limit int) int {
+	floor := start + (limit-start)/2
+	line, sentence, word := -1, -1, -1
+	for i := limit; i > floor; i-- {
+		prev := r[i-1]
+		switch {
+		case prev == '\n' && i >= 2 && r[i-2] == '\n':
+			// Never leave a heading dangling at the end of a chunk.
+			if !isHeadingStart(r, lineStart(r, i-2)) {
+				return i
+			}
+		case prev == '\n' && line < 0:
+			if isHeadingStart(r, i) {
+				return i
+			}
+			line = i
+		case unicode.IsSpace(prev) && sentence < 0 && i >= 2 && strings.ContainsRune(".!?", r[i-2]):
+			sentence = i
+		case unicode.IsSpace(prev) && word < 0:
+			word = i
+		}
+	}
+	for _, b := range []int{line, sentence, word} {
+		if b > 0 {
+			return b
+		}
+	}
+	return limit
+}
+
+// isHeadingStart reports whether a Markdown heading or a PDF page marker
+// begins at offset i.
+func isHeadingStart(r []rune, i int) bool {
+	rest := string(r[i:min(len(r), i+16)])
+	return strings.HasPrefix(rest, "#") || strings.HasPrefix(rest, "--- Page ")
+}
+
+// lineStart returns the offset of the start of the line containing offset i.
+func lineStart(r []rune, i int) int {
+	for i > 0 && r[i-1] != '\n' {
+		i--
+	}
+	return i
+}
+
+func skipSpace(r []rune, i int) int {
+	for i < len(r) && unicode.IsSpace(r[i]) {
+		i++
+	}
+	return i
+}
+
+// approxTokens estimates the token count of s for LLM context budgeting,
+// using the common ~4 characters per token heuristic.
+func approxTokens(s string) int {
+	n := len([]rune(s))
+	return (n + 3) / 4
+}
+
+// selectChunk returns chunk k (1-based) or an error naming the valid range.
+func selectChunk(chunks []contentChunk, k int) (contentChunk, error) {
+	if k < 1 || k > len(chunks) {
+		return contentChunk{}, fmt.Errorf("chunk %d out of range (content has %d chunks)", k, len(chunks))
+	}
+	return chunks[k-1], nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// runContentMulti fetches several URLs concurrently. Records are written as
+// a JSON array (or text documents) in input order once all are done, or as
+// NDJSON in completion order with --stream. Failed URLs produce records with
+// error set and do not stop the run, but a run where every URL failed returns
+// an allFailedError.
+func runContentMulti(client *http.Client, urls []string, opts contentOptions) error {
+	records := make([]contentOutput, len(urls))
+	enc := json.NewEncoder(os.Stdout)
+	var mu sync.Mutex
+	failed := 0
+	var firstErr error
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(opts.concurrency, len(urls)) {
+		wg.Go(func() {
+			for i := range jobs {
+				out, err := fetchContentRecord(context.Background(), client, urls[i], opts)
+				records[i] = out
+				mu.Lock()
+				if err != nil {
+					failed++
+					firstErr = cmp.Or(firstErr, err)
+				}
+				if opts.stream {
+					_ = enc.Encode(out)
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	switch {
+	case opts.stream:
+	case opts.jsonOut:
+		if err := writeJSON(records); err != nil {
+			return err
+		}
+	default:
+		for i, out := range records {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("===== [%d/%d] %s =====\n\n", i+1, len(records), out.URL)
+			if out.Error != nil {
+				fmt.Printf("Error: %s\n", out.Error.Message)
+				continue
+			}
+			printContentText(out, opts)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "[content: %d urls, %d failed]\n", len(urls), failed)
+	if failed == len(urls) {
+		return allFailedError(len(urls), "urls", firstErr)
+	}
+	return nil
+}
+
+// fetchContentRecord fetches one URL and builds its output record, applying
+// chunking. The returned error is also recorded in the record's Error field.
+func fetchContentRecord(ctx context.Context, client *http.Client, rawURL string, opts contentOptions) (contentOutput, error) {
+	out := contentOutput{URL: rawURL, Format: opts.fetch.format}
+	parsedURL, err := validateRemoteFetchURL(rawURL)
+	if err != nil {
+		out.Error = newErrorInfo(err)
+		return out, err
+	}
+	out.URL = parsedURL.String()
+
+	// Chunks are wrapped one by one rather than splitting the markers.
+	fetch := opts.fetch
+	fetch.wrapUntrusted = fetch.wrapUntrusted && opts.chunkSize == 0
+	page, err := fetchPageContent(ctx, client, out.URL, fetch)
+	fillContentRecord(&out, page)
+
+	if opts.fetch.links != nil {
+		out.Links = page.Links
+		out.Content = ""
+	} else if err == nil && opts.chunkSize > 0 {
+		out.TotalChars = len([]rune(page.Content))
+		out.Chunks, out.TotalChunks, err = pageChunks(page.Content, opts)
+		out.Content = ""
+		if opts.fetch.wrapUntrusted {
+			for i := range out.Chunks {
+				out.Chunks[i].Content = wrapUntrusted(out.Chunks[i].Content, out.URL)
+			}
+		}
+	}
+	if err != nil {
+		out.Error = newErrorInfo(err)
+	}
+	return out, err
+}
+
+// fillContentRecord copies the extracted page into out.
+func fillContentRecord(out *contentOutput, page pageContent) {
+	out.CanonicalURL = page.CanonicalURL
+	out.Title = page.Title
+	out.ContentType = page.ContentType
+	out.Charset = page.Charset
+	out.Pages = page.Pages
+	out.Content = page.Content
+	out.Entries = page.Entries
+	out.Metadata = page.Metadata.orNil()
+	out.WaitMS = page.WaitMS
+	out.Retries = page.Retries
+	out.HiddenChars = page.HiddenChars
+	out.RiskScore = page.RiskScore
+	out.RiskSpans = page.RiskSpans
+}
+
+// printContentText prints a record in text mode: the title as a heading,
+// then the content, its chunks or its links.
+func printContentText(out contentOutput, opts contentOptions) {
+	if out.Title != "" && opts.fetch.format != formatHTML {
+		fmt.Printf("# %s\n\n", out.Title)
+		if line := metadataLine(out.Metadata); line != "" {
+			fmt.Printf("%s\n\n", line)
+		}
+	}
+	if line := riskLine(out.RiskScore, out.RiskSpans); line != "" {
+		fmt.Printf("%s\n\n", line)
+	}
+	if opts.fetch.links != nil {
+		if len(out.Links) == 0 {
+			fmt.Println("No links found.")
+		}
+		printLinks(out.Links)
+		return
+	}
+	if out.Chunks == nil {
+		fmt.Println(out.Content)
+		return
+	}
+	for i, c := range out.Chunks {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("--- Chunk %d/%d (chars %d-%d, ~%d tokens) ---\n\n", c.Index, out.TotalChunks, c.Start, c.End, c.Tokens)
+		fmt.Println(c.Content)
+	}
+}
+
+// pageChunks splits extracted content per the chunk options. With --chunk
+// only the requested chunk is returned; total is always the full count.
+func pageChunks(content string, opts contentOptions) (chunks []contentChunk, total int, err error) {
+	chunks = chunkContent(content, opts.chunkSize, opts.chunkOverlap)
+	total = len(chunks)
+	if opts.chunk > 0 {
+		c, err := selectChunk(chunks, opts.chunk)
+		if err != nil {
+			return nil, total, err
+		}
+		chunks = []contentChunk{c}
+	}
+	return chunks, total, nil
+}
+
+// readURLList reads one URL per line, skipping blank lines and lines starting
+// with "#".
+func readURLList(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading URLs from stdin: %w", err)
+	}
+	return urls, nil
+}
+
+// stdinIsPiped reports whether stdin is a pipe or file rather than a terminal.
+func stdinIsPiped() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice == 0
+}
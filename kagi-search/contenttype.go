@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Content types reported in contentOutput.ContentType.
+const (
+	contentHTML = "html"
+	contentPDF  = "pdf"
+	contentJSON = "json"
+	contentText = "text"
+	contentFeed = "feed"
+	contentXML  = "xml"
+)
+
+type feedEntry struct {
+	Title     string `json:"title,omitempty"`
+	Link      string `json:"link,omitempty"`
+	Published string `json:"published,omitempty"`
+	Summary   string `json:"summary,omitempty"`
+}
+
+// detectContentType classifies a response from its Content-Type header,
+// falling back to sniffing the body when the header is missing or generic.
+func detectContentType(header string, body []byte) string {
+	if isPDF(header, body) {
+		return contentPDF
+	}
+
+	mediaType, ok := specificMediaType(header)
+	if !ok {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	mediaType = strings.ToLower(mediaType)
+
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return contentHTML
+	case mediaType == "application/rss+xml" || mediaType == "application/atom+xml" || mediaType == "application/rdf+xml":
+		return contentFeed
+	case mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json"):
+		return contentJSON
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		if isFeedXML(body) {
+			return contentFeed
+		}
+		return contentXML
+	case strings.HasPrefix(mediaType, "text/"):
+		// Raw file hosts serve JSON and XML as text/plain.
+		trimmed := bytes.TrimSpace(body)
+		if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+			return contentJSON
+		}
+		if isFeedXML(body) {
+			return contentFeed
+		}
+		if bytes.HasPrefix(trimmed, []byte("<?xml")) {
+			return contentXML
+		}
+		return contentText
+	}
+	return contentHTML
+}
+
+// genericMediaTypes say nothing about the format of a response body.
+var genericMediaTypes = map[string]bool{
+	"application/octet-stream":   true,
+	"binary/octet-stream":        true,
+	"application/unknown":        true,
+	"application/download":       true,
+	"application/x-download":     true,
+	"application/force-download": true,
+}
+
+// specificMediaType returns the lowercase media type of a Content-Type
+// header, and false when it is missing, malformed or generic.
+func specificMediaType(header string) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil || mediaType == "" || genericMediaTypes[mediaType] {
+		return "", false
+	}
+	return mediaType, true
+}
+
+// extractDocument dispatches a response body to the extractor for its content
+// type, decoding text formats to UTF-8 first. pageURL is the final URL after
+// redirects.
+func extractDocument(body []byte, contentType string, pageURL *url.URL, opts fetchOptions) (pageContent, error) {
+	kind := detectContentType(contentType, body)
+	charsetName := ""
+	if kind != contentPDF {
+		body, charsetName = decodeBody(body, contentType)
+	}
+
+	page := pageContent{ContentType: kind}
+	var err error
+	switch kind {
+	case contentPDF:
+		page.Title, page.Content, page.Pages, err = extractPDF(body, opts.pages)
+	case contentJSON:
+		page.Title = titleFromURL(pageURL)
+		page.Content = extractJSON(body, opts.format)
+	case contentText:
+		page.Title = titleFromURL(pageURL)
+		page.Content = extractPlainText(body)
+	case contentFeed:
+		page.Title, page.Content, page.Entries, err = extractFeed(body, pageURL, opts.format)
+	case contentXML:
+		page.Title = titleFromURL(pageURL)
+		page.Content = extractXMLText(body)
+	default:
+		page, err = extractHTML(string(body), pageURL, opts)
+	}
+	page.Charset = charsetName
+	return page, err
+}
+
+// isFeedXML reports whether the document's root element is an RSS, Atom or
+// RDF (RSS 1.0) feed.
+func isFeedXML(body []byte) bool {
+	dec := xml.NewDecoder(bytes.NewReader(body[:min(len(body), 4096)]))
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			switch strings.ToLower(start.Name.Local) {
+			case "rss", "feed", "rdf":
+				return true
+			}
+			return false
+		}
+	}
+}
+
+// titleFromURL uses the last path segment as a title for documents that have
+// no title of their own (plain text, JSON).
+func titleFromURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	base := path.Base(u.Path)
+	if base == "/" || base == "." {
+		return u.Hostname()
+	}
+	if unescaped, err := url.PathUnescape(base); err == nil {
+		return unescaped
+	}
+	return base
+}
+
+func extractPlainText(body []byte) string {
+	s := strings.ReplaceAll(string(body), "\r\n", "\n")
+	return strings.TrimSpace(s)
+}
+
+// extractJSON pretty-prints a JSON document. In markdown format the result is
+// wrapped in a fenced code block.
+func extractJSON(body []byte, format string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, bytes.TrimSpace(body), "", "  "); err != nil {
+		return extractPlainText(body)
+	}
+	if format == formatMarkdown {
+		return "```json\n" + buf.String() + "\n```"
+	}
+	return buf.String()
+}
+
+// extractXMLText returns the character data of a generic XML document, one
+// element's text per line.
+func extractXMLText(body []byte) string {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.Strict = false
+	dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	lines := make([]string, 0, 64)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if data, ok := tok.(xml.CharData); ok {
+			if line := cleanLine(string(data)); line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// feedDoc covers RSS 2.0 (<rss><channel><item>), RSS 1.0 (<rdf:RDF><item>)
+// and Atom (<feed><entry>) in a single decoding pass.
+type feedDoc struct {
+	XMLName xml.Name
+	Title   string     `xml:"title"`
+	Channel *feedChan  `xml:"channel"`
+	Items   []feedItem `xml:"item"`
+	Entries []feedItem `xml:"entry"`
+}
+
+type feedChan struct {
+	Title string     `xml:"title"`
+	Items []feedItem `xml:"item"`
+}
+
+type feedItem struct {
+	Title       string     `xml:"title"`
+	Links       []feedLink `xml:"link"`
+	GUID        string     `xml:"guid"`
+	PubDate     string     `xml:"pubDate"`
+	Date        string     `xml:"date"`
+	Published   string     `xml:"published"`
+	Updated     string     `xml:"updated"`
+	Description string     `xml:"description"`
+	Summary     string     `xml:"summary"`
+	Content     string     `xml:"content"`
+}
+
+// feedLink matches both RSS (<link>url</link>) and Atom
+// (<link rel="alternate" href="url"/>) links.
+type feedLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Text string `xml:",chardata"`
+}
+
+// extractFeed parses an RSS or Atom feed into entries and renders them as a
+// readable list.
+func extractFeed(body []byte, base *url.URL, format string) (title, content string, entries []feedEntry, err error) {
+	var doc feedDoc
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.Strict = false
+	dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	if err := dec.Decode(&doc); err != nil {
+		return "", "", nil, fmt.Errorf("could not parse feed: %w", err)
+	}
+
+	items := doc.Entries
+	items = append(items, doc.Items...)
+	title = cleanLine(doc.Title)
+	if doc.Channel != nil {
+		title = cleanLine(doc.Channel.Title)
+		items = append(items, doc.Channel.Items...)
+	}
+
+	for _, it := range items {
+		entry := feedEntry{
+			Title:     cleanLine(it.Title),
+			Link:      resolveFeedLink(it, base),
+			Published: normalizeDate(firstNonEmpty(it.Published, it.PubDate, it.Date, it.Updated)),
+		}
+		summary := firstNonEmpty(it.Summary, it.Description, it.Content)
+		if summary != "" {
+			entry.Summary = truncateRunes(extractReadableText(summary), 300)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return title, "", nil, errors.New("feed has no entries")
+	}
+
+	var sb strings.Builder
+	for i, e := range entries {
+		if format == formatMarkdown {
+			label := e.Title
+			if label == "" {
+				label = e.Link
+			}
+			if e.Link != "" {
+				fmt.Fprintf(&sb, "- [%s](%s)", label, e.Link)
+			} else {
+				fmt.Fprintf(&sb, "- %s", label)
+			}
+			if e.Published != "" {
+				fmt.Fprintf(&sb, " — %s", e.Published)
+			}
+			sb.WriteString("\n")
+			if e.Summary != "" {
+				fmt.Fprintf(&sb, "  %s\n", e.Summary)
+			}
+			continue
+		}
+		fmt.Fprintf(&sb, "%d. %s\n", i+1, e.Title)
+		if e.Link != "" {
+			fmt.Fprintf(&sb, "   Link: %s\n", e.Link)
+		}
+		if e.Published != "" {
+			fmt.Fprintf(&sb, "   Date: %s\n", e.Published)
+		}
+		if e.Summary != "" {
+			fmt.Fprintf(&sb, "   %s\n", e.Summary)
+		}
+		sb.WriteString("\n")
+	}
+	return title, strings.TrimSpace(sb.String()), entries, nil
+}
+
+func resolveFeedLink(it feedItem, base *url.URL) string {
+	raw := ""
+	for _, l := range it.Links {
+		href := strings.TrimSpace(firstNonEmpty(l.Href, l.Text))
+		if href == "" {
+			continue
+		}
+		if l.Rel == "" || l.Rel == "alternate" {
+			raw = href
+			break
+		}
+		if raw == "" {
+			raw = href
+		}
+	}
+	if raw == "" && strings.HasPrefix(it.GUID, "http") {
+		raw = strings.TrimSpace(it.GUID)
+	}
+	if raw == "" {
+		return ""
+	}
+	ref, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if base != nil {
+		ref = base.ResolveReference(ref)
+	}
+	return ref.String()
+}
+
+// dateLayouts are the date formats seen in feeds, sitemaps and page metadata.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// normalizeDate converts the common feed and metadata date formats to
+// RFC 3339 (UTC) and returns anything else unchanged.
+func normalizeDate(s string) string {
+	s = strings.TrimSpace(s)
+	if t, ok := parseDate(s); ok {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+// parseDate parses s in any of dateLayouts.
+func parseDate(s string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
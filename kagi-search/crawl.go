@@ -0,0 +1,301 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxCrawlPages = 500
+
+// crawlRecord is one NDJSON output line of crawl: a content record tagged
+// with the link depth at which the page was found.
+type crawlRecord struct {
+	Depth int `json:"depth"`
+	contentOutput
+}
+
+type crawlOptions struct {
+	startURL      string
+	depth         int
+	maxPages      int
+	include       []*regexp.Regexp // a followed URL's path must match one, if any
+	exclude       []*regexp.Regexp // a followed URL's path must match none
+	timeoutSec    int
+	concurrency   int
+	respectRobots bool
+	fetch         fetchOptions
+}
+
+// crawlTarget is a page queued for fetching.
+type crawlTarget struct {
+	url   string
+	depth int
+}
+
+func runCrawl(args []string) error {
+	opts, help, err := parseCrawlArgs(args)
+	if help {
+		printCrawlUsage()
+		return nil
+	}
+	if err != nil {
+		return usageError(err, printCrawlUsage)
+	}
+
+	start, err := validateRemoteFetchURL(opts.startURL)
+	if err != nil {
+		return err
+	}
+	client := newSafeContentClient(time.Duration(opts.timeoutSec) * time.Second)
+	opts.fetch.robots = newRobotsPolicy(opts.respectRobots, botUserAgent())
+
+	pages, failed, firstErr := crawl(context.Background(), client, start, opts, json.NewEncoder(os.Stdout))
+	fmt.Fprintf(os.Stderr, "[crawl: %d pages, %d failed]\n", pages, failed)
+	if pages > 0 && failed == pages {
+		return allFailedError(pages, "pages", firstErr)
+	}
+	return nil
+}
+
+// crawl fetches start and the same-host pages it links to, level by level up
+// to opts.depth, writing one record per page to enc as it completes. URLs are
+// deduplicated by canonical URL, both before fetching and by the canonical
+// URL a page declares. firstErr is the first failure, if any.
+func crawl(ctx context.Context, client *http.Client, start *url.URL, opts crawlOptions, enc *json.Encoder) (pages, failed int, firstErr error) {
+	seen := map[string]bool{dedupeKey(start.String()): true}
+	frontier := []crawlTarget{{url: start.String()}}
+
+	var mu sync.Mutex
+	for len(frontier) > 0 && pages < opts.maxPages {
+		frontier = frontier[:min(len(frontier), opts.maxPages-pages)]
+		var next []crawlTarget
+
+		jobs := make(chan crawlTarget)
+		var wg sync.WaitGroup
+		for range min(opts.concurrency, len(frontier)) {
+			wg.Go(func() {
+				for t := range jobs {
+					page, err := fetchPageContent(ctx, client, t.url, opts.fetch)
+					rec := crawlRecord{Depth: t.depth, contentOutput: contentOutput{URL: t.url, Format: opts.fetch.format}}
+					fillContentRecord(&rec.contentOutput, page)
+					if err != nil {
+						rec.Error = newErrorInfo(err)
+					}
+
+					mu.Lock()
+					if page.CanonicalURL != "" {
+						key := dedupeKey(page.CanonicalURL)
+						if key != dedupeKey(t.url) && seen[key] {
+							// Another URL of an already crawled page.
+							mu.Unlock()
+							continue
+						}
+						seen[key] = true
+					}
+					pages++
+					if err != nil {
+						failed++
+						firstErr = cmp.Or(firstErr, err)
+					}
+					_ = enc.Encode(rec)
+					if t.depth < opts.depth {
+						for _, l := range page.Links {
+							if key := dedupeKey(l.URL); !seen[key] && opts.follows(l.URL, start) {
+								seen[key] = true
+								next = append(next, crawlTarget{url: l.URL, depth: t.depth + 1})
+							}
+						}
+					}
+					mu.Unlock()
+				}
+			})
+		}
+		for _, t := range frontier {
+			jobs <- t
+		}
+		close(jobs)
+		wg.Wait()
+
+		frontier = next
+	}
+	return pages, failed, firstErr
+}
+
+// follows reports whether a discovered link is in scope: on the start page's
+// host and allowed by the include and exclude patterns.
+func (o crawlOptions) follows(rawURL string, start *url.URL) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || !sameSite(u, start) {
+		return false
+	}
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	for _, re := range o.exclude {
+		if re.MatchString(path) {
+			return false
+		}
+	}
+	if len(o.include) == 0 {
+		return true
+	}
+	for _, re := range o.include {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCrawlArgs(args []string) (opts crawlOptions, help bool, err error) {
+	opts = crawlOptions{
+		depth:         2,
+		maxPages:      20,
+		timeoutSec:    20,
+		concurrency:   defaultContentConcurrency,
+		respectRobots: respectRobotsDefault(),
+		fetch: fetchOptions{
+			maxChars: 20000,
+			format:   formatText,
+			// Same-host links are what the crawl follows; they are not
+			// included in the output.
+			links: &linkOptions{sameHost: true},
+		},
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case flagHelpShort, flagHelpLong:
+			return opts, true, nil
+		case flagJSON:
+			// Crawl output is always NDJSON; accept --json for symmetry with content.
+		case "--respect-robots", "--ignore-robots":
+			opts.respectRobots = arg == "--respect-robots"
+		case "--wrap-untrusted":
+			opts.fetch.wrapUntrusted = true
+		case "--keep-hidden":
+			opts.fetch.keepHidden = true
+		case "--include", "--exclude":
+			if i+1 >= len(args) {
+				return opts, false, fmt.Errorf("missing value for %s", arg)
+			}
+			i++
+			re, err := regexp.Compile(args[i])
+			if err != nil {
+				return opts, false, fmt.Errorf("invalid %s pattern: %w", arg, err)
+			}
+			if arg == "--include" {
+				opts.include = append(opts.include, re)
+			} else {
+				opts.exclude = append(opts.exclude, re)
+			}
+		case "--selector", "--exclude-selector":
+			if i+1 >= len(args) {
+				return opts, false, fmt.Errorf("missing value for %s", arg)
+			}
+			i++
+			sel, err := parseSelector(arg, args[i])
+			if err != nil {
+				return opts, false, err
+			}
+			if arg == "--selector" {
+				opts.fetch.selector = sel
+			} else {
+				opts.fetch.exclude = sel
+			}
+		case "--depth", "--max-pages", "--timeout", "--max-chars", "--concurrency":
+			if i+1 >= len(args) {
+				return opts, false, fmt.Errorf("missing value for %s", arg)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				return opts, false, fmt.Errorf("invalid value for %s: %s", arg, args[i])
+			}
+			switch arg {
+			case "--depth":
+				opts.depth = n
+			case "--max-pages":
+				opts.maxPages = n
+			case "--timeout":
+				opts.timeoutSec = n
+			case "--max-chars":
+				opts.fetch.maxChars = n
+			case "--concurrency":
+				opts.concurrency = n
+			}
+		case "--format":
+			if i+1 >= len(args) {
+				return opts, false, errors.New("missing value for --format")
+			}
+			i++
+			switch f := strings.ToLower(args[i]); f {
+			case formatText, formatMarkdown, formatHTML:
+				opts.fetch.format = f
+			case "md":
+				opts.fetch.format = formatMarkdown
+			default:
+				return opts, false, fmt.Errorf("unknown format %q — valid: text, markdown, html", args[i])
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return opts, false, fmt.Errorf("unknown option: %s", arg)
+			}
+			if opts.startURL != "" {
+				return opts, false, errors.New("crawl accepts a single start URL")
+			}
+			opts.startURL = strings.TrimSpace(arg)
+		}
+	}
+
+	if opts.startURL == "" {
+		return opts, false, errors.New("url is required")
+	}
+	opts.timeoutSec = max(opts.timeoutSec, 1)
+	opts.maxPages = min(max(opts.maxPages, 1), maxCrawlPages)
+	opts.concurrency = min(max(opts.concurrency, 1), 16)
+	return opts, false, nil
+}
+
+func printCrawlUsage() {
+	fmt.Println("Usage: kagi-search crawl <url> [--depth <num>] [--max-pages <num>] [--include <re>] [--exclude <re>]")
+	fmt.Println()
+	fmt.Println("Fetches the page and the same-host pages it links to, breadth first, and")
+	fmt.Println("writes one NDJSON content record per page (with its link depth) as it completes.")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --depth <num>         Link hops to follow from the start page (default: 2)")
+	fmt.Println("  --max-pages <num>     Max pages to fetch (default: 20, max: 500)")
+	fmt.Println("  --include <re>        Only follow URLs whose path matches (repeatable)")
+	fmt.Println("  --exclude <re>        Never follow URLs whose path matches (repeatable)")
+	fmt.Println("  --concurrency <num>   Pages fetched in parallel (default: 4, max: 16)")
+	fmt.Println("  --timeout <sec>       HTTP timeout in seconds (default: 20)")
+	fmt.Println("  --max-chars <num>     Max chars per page (default: 20000)")
+	fmt.Println("  --format <fmt>        Output format: text, markdown or html (default: text)")
+	fmt.Println("  --selector <css>      Extract only elements matching the CSS selector (HTML)")
+	fmt.Println("  --exclude-selector <css> Drop matching HTML elements before extraction")
+	fmt.Println("  --respect-robots      Honor robots.txt, X-Robots-Tag and meta robots")
+	fmt.Println("  --wrap-untrusted      Wrap content in <untrusted-content> markers")
+	fmt.Println("  --keep-hidden         Keep hidden and off-screen HTML elements (debugging)")
+	fmt.Println("  --ignore-robots       Disable robots handling enabled by KAGI_RESPECT_ROBOTS")
+	fmt.Println()
+	fmt.Println("Environment:")
+	fmt.Println("  KAGI_RESPECT_ROBOTS   Set to 1 to honor robots directives by default")
+	fmt.Println("  KAGI_BOT_USER_AGENT   User-Agent sent when robots handling is enabled")
+	fmt.Println("  KAGI_CONTENT_ALLOW_CIDRS Addresses pages may be fetched from despite the SSRF guard")
+	fmt.Println("  KAGI_CONTENT_DENY_CIDRS  Extra addresses pages are never fetched from")
+	fmt.Println("  KAGI_CONTENT_PROXY    Proxy for page fetches: http://, https:// or socks5:// URL")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Error kinds, reported as "kind" in JSON errors. Each has its own exit code.
+const (
+	kindInternal  = "internal"
+	kindUsage     = "usage"
+	kindAuth      = "auth"
+	kindBalance   = "balance"
+	kindRateLimit = "rate_limit"
+	kindNetwork   = "network"
+	kindAPI       = "api"
+	kindBlocked   = "blocked"
+	kindFetch     = "fetch"
+)
+
+// exitCodes are the documented process exit codes of each error kind. They
+// are the same in all kagi-* tools.
+var exitCodes = map[string]int{
+	kindInternal:  1,
+	kindUsage:     2,
+	kindAuth:      3,
+	kindBalance:   4,
+	kindRateLimit: 5,
+	kindNetwork:   6,
+	kindAPI:       7,
+	kindBlocked:   8,
+	kindFetch:     9,
+}
+
+// cliError is an error with a kind, reported by main with the kind's exit
+// code and, with --json, as a structured error object.
+type cliError struct {
+	kind      string
+	status    int // HTTP status, for API and page fetch errors
+	apiCode   int // Kagi error[].code, when the API sent one
+	retryable bool
+	usage     func() // printed before usage errors in text mode
+	reported  bool   // the failures are already in the command's output
+	err       error
+}
+
+func (e *cliError) Error() string { return e.err.Error() }
+func (e *cliError) Unwrap() error { return e.err }
+
+// errorOutput is the --json form of a failed command.
+type errorOutput struct {
+	Error errorInfo `json:"error"`
+}
+
+// errorInfo describes an error in JSON output, both for a failed command and
+// in the records of failed items of multi-item commands.
+type errorInfo struct {
+	Code      int    `json:"code"` // exit code
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	Status    int    `json:"status,omitempty"`
+	APICode   int    `json:"api_code,omitempty"`
+}
+
+func kindError(kind string, err error) error {
+	return &cliError{kind: kind, err: err}
+}
+
+// usageError marks err as caused by invalid arguments; usage, if not nil,
+// prints the command's usage text.
+func usageError(err error, usage func()) error {
+	return &cliError{kind: kindUsage, usage: usage, err: err}
+}
+
+// missingKeyError is returned when KAGI_API_KEY is not set.
+func missingKeyError() error {
+	return kindError(kindAuth, errors.New("KAGI_API_KEY environment variable is required (https://kagi.com/settings/api)"))
+}
+
+// apiErrorKinds maps Kagi error[].code values to error kinds.
+var apiErrorKinds = map[int]string{
+	2:   kindAuth,    // missing or invalid API token
+	101: kindBalance, // insufficient credit
+}
+
+// apiError classifies a non-2xx Kagi API response. A known error[].code in the
+// body decides the kind; without one, the HTTP status and message are used.
+func apiError(status int, body []byte) error {
+	var errResp struct {
+		Error []struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		} `json:"error"`
+	}
+	e := &cliError{kind: kindAPI, status: status}
+	msg := strings.TrimSpace(string(body))
+	hasCode := false
+	if json.Unmarshal(body, &errResp) == nil && len(errResp.Error) > 0 {
+		e.apiCode = errResp.Error[0].Code
+		msg = errResp.Error[0].Msg
+		hasCode = true
+	} else if len(msg) > 500 {
+		msg = msg[:500] + "..."
+	}
+	if msg == "" {
+		msg = http.StatusText(status)
+	}
+	e.err = fmt.Errorf("HTTP %d: %s", status, msg)
+
+	lower := strings.ToLower(msg)
+	kind, known := apiErrorKinds[e.apiCode]
+	switch {
+	case hasCode && known:
+		e.kind = kind
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		e.kind = kindAuth
+	case status == http.StatusPaymentRequired || (!hasCode && (strings.Contains(lower, "insufficient") ||
+		strings.Contains(lower, "balance") || strings.Contains(lower, "credit"))):
+		e.kind = kindBalance
+	case status == http.StatusTooManyRequests:
+		e.kind = kindRateLimit
+	}
+	e.retryable = e.kind == kindRateLimit || (e.kind == kindAPI && status >= 500)
+	return e
+}
+
+// fetchError reports a page that could not be fetched or extracted.
+func fetchError(status int, err error) error {
+	return &cliError{
+		kind:      kindFetch,
+		status:    status,
+		retryable: status == http.StatusTooManyRequests || status >= 500,
+		err:       err,
+	}
+}
+
+// newErrorInfo returns the JSON description of err.
+func newErrorInfo(err error) *errorInfo {
+	e := classifyError(err)
+	return &errorInfo{
+		Code:      exitCodes[e.kind],
+		Kind:      e.kind,
+		Message:   err.Error(),
+		Retryable: e.retryable,
+		Status:    e.status,
+		APICode:   e.apiCode,
+	}
+}
+
+// allFailedError is returned by commands that report per-item errors in their
+// output (content with several URLs, crawl, batch) when every item failed.
+// It carries the kind of first, the first failure, so the exit code tells
+// total failure from success without another JSON object on stdout.
+func allFailedError(n int, noun string, first error) error {
+	return &cliError{
+		kind:     classifyError(first).kind,
+		reported: true,
+		err:      fmt.Errorf("all %d %s failed", n, noun),
+	}
+}
+
+// classifyError returns the cliError describing err. Errors without a kind
+// are network errors if they come from the transport, else internal.
+func classifyError(err error) *cliError {
+	var ce *cliError
+	if errors.As(err, &ce) {
+		return ce
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &cliError{kind: kindNetwork, retryable: true, err: err}
+	}
+	return &cliError{kind: kindInternal, err: err}
+}
+
+// exitWithError reports err and exits with its kind's exit code. With
+// jsonOut the error is written to stdout as an errorOutput object, unless
+// the command already reported its failures there; otherwise it goes to
+// stderr, after the usage text for usage errors.
+func exitWithError(err error, jsonOut bool) {
+	e := classifyError(err)
+	code := exitCodes[e.kind]
+	if jsonOut && !e.reported {
+		_ = writeJSON(errorOutput{Error: *newErrorInfo(err)})
+	} else {
+		if e.usage != nil && !jsonOut {
+			e.usage()
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io"
+	"maps"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// nonTextElements never hold readable text, so they are dropped along with
+// everything inside them even from an explicit --selector match.
+var nonTextElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Template: true,
+}
+
+// noiseElements are page chrome and non-text elements that the fallback
+// extractors drop along with everything inside them.
+var noiseElements = func() map[atom.Atom]bool {
+	m := maps.Clone(nonTextElements)
+	for _, a := range []atom.Atom{atom.Nav, atom.Header, atom.Footer, atom.Aside} {
+		m[a] = true
+	}
+	return m
+}()
+
+// textBlockElements start and end a paragraph in extractReadableText.
+var textBlockElements = map[atom.Atom]bool{
+	atom.Title: true, atom.Body: true,
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Blockquote: true, atom.Pre: true, atom.Figure: true, atom.Figcaption: true,
+	atom.Table: true, atom.Tr: true, atom.Caption: true, atom.Hr: true, atom.Br: true,
+	atom.Form: true, atom.Fieldset: true, atom.Details: true, atom.Summary: true,
+	atom.Address: true,
+}
+
+// voidElements never have an end tag, so they are not tracked as open.
+var voidElements = map[atom.Atom]bool{
+	atom.Area: true, atom.Base: true, atom.Br: true, atom.Col: true, atom.Embed: true,
+	atom.Hr: true, atom.Img: true, atom.Input: true, atom.Link: true, atom.Meta: true,
+	atom.Source: true, atom.Track: true, atom.Wbr: true,
+}
+
+// extractReadableText is the plain-text fallback for pages readability
+// cannot handle. It streams the document through the HTML tokenizer in a
+// single pass, dropping comments and noiseElements and starting a new
+// paragraph at each block element.
+//
+// Open elements are kept on a stack so an end tag also closes the elements
+// left open inside it, as browsers do: an unclosed <nav> ends with its
+// parent instead of swallowing the rest of the page.
+func extractReadableText(htmlDoc string) string {
+	return extractText(htmlDoc, noiseElements)
+}
+
+// extractText converts htmlDoc to paragraphs of plain text, dropping comments
+// and the skip elements with their contents.
+func extractText(htmlDoc string, skip map[atom.Atom]bool) string {
+	z := html.NewTokenizer(strings.NewReader(htmlDoc))
+	var (
+		open      []string           // names of open elements, innermost last
+		openCount = map[string]int{} // open elements per name
+		skipDepth = -1               // len(open) when the outermost noise element opened
+		para      strings.Builder
+		paras     []string
+	)
+	flush := func() {
+		if line := cleanLine(para.String()); line != "" {
+			paras = append(paras, line)
+		}
+		para.Reset()
+	}
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return ""
+			}
+			flush()
+			return strings.Join(paras, "\n\n")
+
+		case html.TextToken:
+			if skipDepth < 0 {
+				para.Write(z.Text())
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			if skipDepth >= 0 {
+				if tt == html.StartTagToken && !voidElements[a] {
+					open = append(open, tagName(a, name))
+					openCount[open[len(open)-1]]++
+				}
+				continue
+			}
+			switch {
+			case skip[a]:
+				if tt == html.StartTagToken {
+					skipDepth = len(open)
+					open = append(open, tagName(a, name))
+					openCount[open[len(open)-1]]++
+				}
+				continue
+			case textBlockElements[a]:
+				flush()
+			case a == atom.Td || a == atom.Th:
+				para.WriteByte(' ')
+			}
+			if tt == html.StartTagToken && !voidElements[a] {
+				open = append(open, tagName(a, name))
+				openCount[open[len(open)-1]]++
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			// Pop to the matching open element. Stray end tags are ignored
+			// without scanning the stack, and every element is popped at
+			// most once, so this stays linear.
+			if openCount[string(name)] > 0 {
+				for {
+					top := open[len(open)-1]
+					open = open[:len(open)-1]
+					openCount[top]--
+					if top == string(name) {
+						break
+					}
+				}
+			}
+			if skipDepth >= 0 {
+				if len(open) <= skipDepth {
+					skipDepth = -1
+				}
+				continue
+			}
+			switch {
+			case textBlockElements[a]:
+				flush()
+			case a == atom.Td || a == atom.Th:
+				para.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// tagName returns the name of a tag, without allocating for known elements.
+func tagName(a atom.Atom, name []byte) string {
+	if a != 0 {
+		return a.String()
+	}
+	return string(name)
+}
+
+// pruneNoise removes comments and noiseElements from a parsed document, for
+// the Markdown and HTML fallbacks.
+func pruneNoise(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.CommentNode || (c.Type == html.ElementNode && noiseElements[c.DataAtom]) {
+			n.RemoveChild(c)
+		} else {
+			pruneNoise(c)
+		}
+		c = next
+	}
+}
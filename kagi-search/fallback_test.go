@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// The regex-based fallback that extractReadableText replaced, kept here as
+// the baseline for the benchmarks.
+var (
+	reLegacyComments = regexp.MustCompile(`(?is)<!--.*?-->`)
+	reLegacyNoise    = regexp.MustCompile(`(?is)<(?:script|style|noscript|svg|iframe|nav|header|footer|aside)[^>]*>.*?</(?:script|style|noscript|svg|iframe|nav|header|footer|aside)>`)
+	reLegacyBlocks   = regexp.MustCompile(`(?is)</?(p|div|section|article|main|h[1-6]|li|ul|ol|blockquote|pre|tr|table|hr|br)[^>]*>`)
+)
+
+func legacyExtractReadableText(htmlDoc string) string {
+	s := reLegacyComments.ReplaceAllString(htmlDoc, " ")
+	s = reLegacyNoise.ReplaceAllString(s, "\n")
+	s = reLegacyBlocks.ReplaceAllString(s, "\n")
+	s = reTags.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+
+	var cleaned []string
+	for line := range strings.SplitSeq(s, "\n") {
+		if line = cleanLine(line); line != "" {
+			cleaned = append(cleaned, line)
+		}
+	}
+	return strings.Join(cleaned, "\n\n")
+}
+
+func TestExtractReadableText(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "blocks become paragraphs",
+			html: "<body><h1>Title</h1><p>One <b>bold</b> word.</p><ul><li>a</li><li>b</li></ul></body>",
+			want: "Title\n\nOne bold word.\n\na\n\nb",
+		},
+		{
+			name: "nested chrome",
+			html: "<body><header><nav><ul><li>Home</li></ul><aside>ad</aside></nav>Site</header><p>Body</p></body>",
+			want: "Body",
+		},
+		{
+			name: "unclosed nav ends with its parent",
+			html: "<body><div><nav><a href=\"/\">menu</a></div><p>Kept</p></body>",
+			want: "Kept",
+		},
+		{
+			name: "nested noise of the same name",
+			html: "<body><aside>x<aside>y</aside>still aside</aside><p>Kept</p></body>",
+			want: "Kept",
+		},
+		{
+			name: "stray end tags are ignored",
+			html: "<body></div></nav></aside><p>One</p></span></div><p>Two</p></body>",
+			want: "One\n\nTwo",
+		},
+		{
+			name: "stray end tag inside chrome does not end it",
+			html: "<body><footer>links</p></div>more links</footer><p>Kept</p></body>",
+			want: "Kept",
+		},
+		{
+			name: "scripts, styles and comments",
+			html: "<body><script>var p = '<p>no</p>';</script><style>p{}</style><!-- <p>no</p> --><p>Yes &amp; yes</p></body>",
+			want: "Yes & yes",
+		},
+		{
+			name: "table cells are separated",
+			html: "<table><tr><td>a</td><td>b</td></tr></table>",
+			want: "a b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractReadableText(tt.html); got != tt.want {
+				t.Errorf("extractReadableText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// benchPage builds an HTML page of about size bytes in the shape of a large
+// real-world page: repeated sections of chrome, scripts, comments, nested
+// markup and tables around the article text.
+func benchPage(size int) string {
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html><html><head><title>Benchmark page</title>")
+	sb.WriteString("<style>body{font:16px sans-serif}.x{display:block}</style></head><body>")
+	sb.WriteString(`<header><nav><ul><li><a href="/">Home</a></li><li><a href="/docs">Docs</a></li></ul></nav></header><main><article>`)
+	for i := 0; sb.Len() < size; i++ {
+		fmt.Fprintf(&sb, "<section id=\"s%d\"><h2>Section %d</h2>", i, i)
+		sb.WriteString("<!-- tracking pixel and layout notes -->")
+		fmt.Fprintf(&sb, "<p>Paragraph %d with <a href=\"/p/%d\">a link</a>, <em>emphasis</em> and &amp; entities. ", i, i)
+		sb.WriteString("Enough running text to look like prose in a long article about extraction.</p>")
+		sb.WriteString("<div class=\"card\"><div class=\"body\"><p>Nested <span>inline <b>markup</b></span> inside cards.</p></div></div>")
+		sb.WriteString("<script>window.dataLayer = window.dataLayer || []; dataLayer.push({'event': 'view', 'id': 42});</script>")
+		sb.WriteString("<aside><p>Related: other stories you may like</p></aside>")
+		sb.WriteString("<table><tr><td>cell a</td><td>cell b</td></tr></table>")
+		sb.WriteString("<ul><li>one<li>two<li>three</ul></section>")
+	}
+	sb.WriteString("</article></main><footer><p>Copyright</p></footer></body></html>")
+	return sb.String()
+}
+
+func BenchmarkExtractReadableText(b *testing.B) {
+	for _, size := range []int{64 << 10, 1 << 20, 8 << 20} {
+		page := benchPage(size)
+		b.Run(fmt.Sprintf("tokenizer/%dKB", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(page)))
+			for b.Loop() {
+				extractReadableText(page)
+			}
+		})
+		b.Run(fmt.Sprintf("regex/%dKB", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(page)))
+			for b.Loop() {
+				legacyExtractReadableText(page)
+			}
+		})
+	}
+}
+
+// BenchmarkExtractReadableTextUnclosed measures a page whose <nav> elements
+// are never closed. The regex fallback cannot pair them and keeps their text;
+// the tokenizer ends each one with its parent.
+func BenchmarkExtractReadableTextUnclosed(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("<html><body>")
+	for sb.Len() < 1<<20 {
+		sb.WriteString("<div><nav><a href=\"/\">menu</a></div><p>Body text that should be kept by the extractor.</p>")
+	}
+	sb.WriteString("</body></html>")
+	page := sb.String()
+
+	b.Run("tokenizer", func(b *testing.B) {
+		b.SetBytes(int64(len(page)))
+		for b.Loop() {
+			extractReadableText(page)
+		}
+	})
+	b.Run("regex", func(b *testing.B) {
+		b.SetBytes(int64(len(page)))
+		for b.Loop() {
+			legacyExtractReadableText(page)
+		}
+	})
+}
+
+// BenchmarkExtractReadableTextStrayEndTags measures deeply nested markup
+// followed by thousands of unmatched end tags, which would be quadratic if
+// each one scanned the open-element stack.
+func BenchmarkExtractReadableTextStrayEndTags(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("<html><body>")
+	sb.WriteString(strings.Repeat("<section>", 5000))
+	sb.WriteString("<p>Deep text</p>")
+	sb.WriteString(strings.Repeat("</div>", 50000))
+	sb.WriteString("</body></html>")
+	page := sb.String()
+
+	b.SetBytes(int64(len(page)))
+	for b.Loop() {
+		extractReadableText(page)
+	}
+}
@@ -2,13 +2,16 @@ module github.com/joelazar/kagi-skills/kagi-search
 
 go 1.26
 
-require codeberg.org/readeck/go-readability/v2 v2.1.1
+require (
+	codeberg.org/readeck/go-readability/v2 v2.1.1
+	github.com/andybalholm/cascadia v1.3.3
+	github.com/gogs/chardet v0.0.0-20211120154057-b7413eaefb8f
+	github.com/ledongthuc/pdf v0.0.0-20250511090121-5959a4027728
+	golang.org/x/net v0.41.0
+	golang.org/x/text v0.26.0
+)
 
 require (
-	github.com/andybalholm/cascadia v1.3.3 // indirect
 	github.com/araddon/dateparse v0.0.0-20210429162001-6b43995a97de // indirect
 	github.com/go-shiori/dom v0.0.0-20230515143342-73569d674e1c // indirect
-	github.com/gogs/chardet v0.0.0-20211120154057-b7413eaefb8f // indirect
-	golang.org/x/net v0.41.0 // indirect
-	golang.org/x/text v0.26.0 // indirect
 )
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// hiddenClasses are utility classes that hide an element visually in
+// common CSS frameworks, whatever the page's stylesheets say.
+var hiddenClasses = map[string]bool{
+	"sr-only":            true,
+	"visually-hidden":    true,
+	"screen-reader-text": true,
+	"hidden-visually":    true,
+}
+
+var (
+	reCSSDecl     = regexp.MustCompile(`([a-z-]+)\s*:\s*([^;]+)`)
+	reCSSLength   = regexp.MustCompile(`^(-?[0-9.]+)([a-z%]*)$`)
+	reSimpleCSSEl = regexp.MustCompile(`^([.#])(-?[_a-zA-Z][\w-]*)$`)
+	reCSSNumber   = regexp.MustCompile(`[0-9.]+`)
+)
+
+// removeHidden drops the elements of an HTML document that browsers never
+// show: hidden and aria-hidden elements, <template>s, and elements hidden
+// or moved off screen by inline styles, visually-hidden utility classes or
+// simple class and id rules in the page's <style> blocks. It returns the
+// pruned document and the number of text characters removed; the document
+// is returned unchanged when nothing is hidden.
+func removeHidden(htmlDoc string) (string, int) {
+	doc, err := html.Parse(strings.NewReader(htmlDoc))
+	if err != nil {
+		return htmlDoc, 0
+	}
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return htmlDoc, 0
+	}
+	hiddenSel := hiddenSelectors(doc)
+
+	removed := 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if c.Type == html.ElementNode && isHiddenElement(c, hiddenSel) {
+				removed += hiddenTextLen(c)
+				n.RemoveChild(c)
+			} else {
+				walk(c)
+			}
+			c = next
+		}
+	}
+	walk(body)
+	if removed == 0 {
+		return htmlDoc, 0
+	}
+
+	var sb strings.Builder
+	if err := html.Render(&sb, doc); err != nil {
+		return htmlDoc, 0
+	}
+	return sb.String(), removed
+}
+
+func isHiddenElement(n *html.Node, hiddenSel map[string]bool) bool {
+	if n.DataAtom == atom.Template {
+		return true
+	}
+	for _, a := range n.Attr {
+		switch strings.ToLower(a.Key) {
+		case "hidden":
+			// until-found content is revealed by find-in-page.
+			if !strings.EqualFold(a.Val, "until-found") {
+				return true
+			}
+		case "aria-hidden":
+			if strings.EqualFold(strings.TrimSpace(a.Val), "true") {
+				return true
+			}
+		case "style":
+			if hidingStyle(a.Val) {
+				return true
+			}
+		case "class":
+			for _, c := range strings.Fields(a.Val) {
+				if hiddenClasses[strings.ToLower(c)] || hiddenSel["."+c] {
+					return true
+				}
+			}
+		case "id":
+			if hiddenSel["#"+a.Val] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hidingStyle reports whether CSS declarations hide an element: display
+// none, visibility hidden, zero font size, a clipped or collapsed box, or a
+// position far off screen.
+func hidingStyle(style string) bool {
+	decls := map[string]string{}
+	for _, m := range reCSSDecl.FindAllStringSubmatch(strings.ToLower(style), -1) {
+		v := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(m[2]), "!important"))
+		decls[m[1]] = strings.Join(strings.Fields(v), " ")
+	}
+	switch {
+	case decls["display"] == "none",
+		decls["visibility"] == "hidden" || decls["visibility"] == "collapse",
+		isZeroLength(decls["font-size"]),
+		offScreen(decls["text-indent"]):
+		return true
+	}
+	if pos := decls["position"]; pos == "absolute" || pos == "fixed" {
+		for _, side := range []string{"left", "top", "right"} {
+			if offScreen(decls[side]) {
+				return true
+			}
+		}
+		if clip := decls["clip"]; strings.HasPrefix(clip, "rect(") && tinyClip(clip) {
+			return true
+		}
+	}
+	switch decls["clip-path"] {
+	case "inset(50%)", "inset(100%)":
+		return true
+	}
+	if o := decls["overflow"]; o == "hidden" || o == "clip" {
+		if isZeroLength(decls["height"]) || isZeroLength(decls["width"]) || isZeroLength(decls["max-height"]) {
+			return true
+		}
+	}
+	return false
+}
+
+// tinyClip reports whether a clip rect keeps at most a 1px box, as in the
+// sr-only pattern rect(0 0 0 0) or rect(1px, 1px, 1px, 1px).
+func tinyClip(clip string) bool {
+	for _, n := range reCSSNumber.FindAllString(clip, -1) {
+		if f, err := strconv.ParseFloat(n, 64); err != nil || f > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+func isZeroLength(v string) bool {
+	m := reCSSLength.FindStringSubmatch(v)
+	if m == nil {
+		return false
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	return err == nil && f == 0
+}
+
+// offScreen reports whether a CSS offset moves an element out of view, as
+// in the common left:-9999px technique.
+func offScreen(v string) bool {
+	m := reCSSLength.FindStringSubmatch(v)
+	if m == nil {
+		return false
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return false
+	}
+	switch m[2] {
+	case "px", "pt":
+		return f <= -999
+	case "em", "rem", "ch":
+		return f <= -60
+	case "%", "vw", "vh":
+		return f <= -100
+	}
+	return false
+}
+
+// hiddenSelectors collects the single-class and single-id selectors that
+// top-level rules in the page's <style> blocks hide. Rules inside @media
+// and other at-rules are conditional and ignored, and a selector is dropped
+// again if any rule makes it visible.
+func hiddenSelectors(doc *html.Node) map[string]bool {
+	hidden := map[string]bool{}
+	shown := map[string]bool{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Style && n.FirstChild != nil {
+			forEachCSSRule(n.FirstChild.Data, func(selectors, decls string, topLevel bool) {
+				hides := hidingStyle(decls)
+				for sel := range strings.SplitSeq(selectors, ",") {
+					sel = strings.TrimSpace(sel)
+					if !reSimpleCSSEl.MatchString(sel) {
+						continue
+					}
+					if hides && topLevel {
+						hidden[sel] = true
+					} else if d := strings.ToLower(decls); strings.Contains(d, "display") || strings.Contains(d, "visibility") {
+						shown[sel] = true
+					}
+				}
+			})
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	for sel := range shown {
+		delete(hidden, sel)
+	}
+	return hidden
+}
+
+// forEachCSSRule calls fn for each style rule in css, reporting whether it
+// is outside any at-rule block.
+func forEachCSSRule(css string, fn func(selectors, decls string, topLevel bool)) {
+	var stack []bool // for each open block: whether it is an at-rule block
+	start := 0
+	prelude := ""
+	for i := 0; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			prelude = strings.TrimSpace(css[start:i])
+			stack = append(stack, strings.HasPrefix(prelude, "@"))
+			start = i + 1
+		case '}':
+			if len(stack) == 0 {
+				start = i + 1
+				continue
+			}
+			isAt := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !isAt && prelude != "" {
+				topLevel := true
+				for _, at := range stack {
+					topLevel = topLevel && !at
+				}
+				fn(prelude, css[start:i], topLevel)
+			}
+			prelude = ""
+			start = i + 1
+		}
+	}
+}
+
+// hiddenTextLen counts the visible-looking text characters under n.
+func hiddenTextLen(n *html.Node) int {
+	if n.Type == html.TextNode {
+		return utf8.RuneCountInString(strings.Join(strings.Fields(n.Data), " "))
+	}
+	if n.Type == html.ElementNode && (n.DataAtom == atom.Script || n.DataAtom == atom.Style) {
+		return 0
+	}
+	total := 0
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		total += hiddenTextLen(c)
+	}
+	return total
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxRiskSpans    = 20
+	maxRiskSpanText = 200
+)
+
+// riskSpan is a part of fetched content that looks aimed at a language
+// model rather than a human reader. Field names the text field it was found
+// in ("" for the content itself), and Start is a rune offset into that
+// field after screening.
+type riskSpan struct {
+	Rule  string `json:"rule"`
+	Field string `json:"field,omitempty"`
+	Start int    `json:"start"`
+	Text  string `json:"text"`
+}
+
+// injectionRule is an instruction-like pattern with the risk it adds when
+// found. Each rule counts once, however often it matches.
+type injectionRule struct {
+	name   string
+	weight float64
+	re     *regexp.Regexp
+}
+
+var injectionRules = []injectionRule{
+	{"ignore_instructions", 0.6, regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override)\s+(?:all\s+|any\s+|the\s+)?(?:previous|prior|above|earlier|preceding|your|system)\s+(?:instructions?|prompts?|directions|rules|guidelines|context)`)},
+	{"role_tag", 0.5, regexp.MustCompile(`(?i)</?(?:system|assistant|user|developer|tool)>|<\|(?:im_start|im_end|system|assistant|endoftext)\|>|\[/?INST\]|<</?SYS>>`)},
+	// "System:" lines also occur in ordinary docs ("System: Linux"), so
+	// they stay below the warning threshold unless something else matches.
+	{"role_line", 0.3, regexp.MustCompile(`(?im)^\s*#{0,3}\s*(?:system|assistant)\s*:`)},
+	{"new_instructions", 0.4, regexp.MustCompile(`(?i)\b(?:new|updated|real|actual)\s+(?:instructions|system\s+prompt)\s*:`)},
+	{"persona_override", 0.4, regexp.MustCompile(`(?i)\byou\s+are\s+now\s+(?:a|an|in|the)\b|\bdeveloper\s+mode\b|\bjailbreak`)},
+	{"prompt_leak", 0.5, regexp.MustCompile(`(?i)\b(?:reveal|print|show|repeat|output|leak)\s+(?:your|the)\s+(?:system\s+prompt|initial\s+instructions|hidden\s+prompt|instructions)`)},
+	{"addresses_model", 0.3, regexp.MustCompile(`(?i)\b(?:attention|note|message|instructions)\s+(?:to|for)\s+(?:the\s+)?(?:ai|llm|language\s+model|assistant|chatbot|agent)s?\b|\bif\s+you\s+are\s+an?\s+(?:ai|llm|language\s+model|assistant|agent)\b`)},
+	{"exfiltration", 0.5, regexp.MustCompile(`(?i)\b(?:send|post|upload|exfiltrate|forward)\s+(?:the\s+|your\s+|all\s+|any\s+)?(?:conversation|chat\s+history|api\s+keys?|credentials|secrets|tokens|system\s+prompt)`)},
+}
+
+// Weights of the invisible-character rules.
+var invisibleWeights = map[string]float64{
+	"unicode_tags":  0.6,
+	"bidi_control":  0.2,
+	"zero_width":    0.1,
+	"invisible_ops": 0.1,
+}
+
+// invisibleKind classifies characters that render as nothing but are still
+// read by a model. It returns "" for ordinary characters.
+func invisibleKind(r rune) string {
+	switch {
+	case r >= 0xE0000 && r <= 0xE007F:
+		return "unicode_tags"
+	case r == 0x200E || r == 0x200F || r == 0x061C || (r >= 0x202A && r <= 0x202E) || (r >= 0x2066 && r <= 0x2069):
+		return "bidi_control"
+	case r == 0x200B || r == 0x200C || r == 0x200D || r == 0x2060 || r == 0xFEFF || r == 0x180E:
+		return "zero_width"
+	case r >= 0x2061 && r <= 0x2064:
+		return "invisible_ops"
+	}
+	return ""
+}
+
+// screenPage strips invisible characters from the content and every other
+// page field that is passed on to a model, and sets the page's risk score
+// and spans from what it found.
+func screenPage(page *pageContent) {
+	var sc screener
+	page.Content = sc.screen("", page.Content)
+	page.Title = sc.screen("title", page.Title)
+	page.Metadata.Byline = sc.screen("metadata.byline", page.Metadata.Byline)
+	page.Metadata.SiteName = sc.screen("metadata.site_name", page.Metadata.SiteName)
+	page.Metadata.Excerpt = sc.screen("metadata.excerpt", page.Metadata.Excerpt)
+	for i := range page.Entries {
+		e := &page.Entries[i]
+		e.Title = sc.screen(fmt.Sprintf("entries[%d].title", i), e.Title)
+		e.Summary = sc.screen(fmt.Sprintf("entries[%d].summary", i), e.Summary)
+	}
+	for i := range page.Links {
+		page.Links[i].Text = sc.screen(fmt.Sprintf("links[%d].text", i), page.Links[i].Text)
+	}
+	page.RiskScore, page.RiskSpans = sc.result()
+}
+
+// screener runs the screening pass over the content and the other text
+// fields of a page, combining their findings into one risk score.
+type screener struct {
+	found map[string]float64
+	spans []riskSpan
+}
+
+// screen strips invisible characters from s and records the rules it
+// matches. field names where s came from, e.g. "title"; "" is the content.
+func (sc *screener) screen(field, s string) string {
+	if s == "" {
+		return ""
+	}
+	if sc.found == nil {
+		sc.found = map[string]float64{}
+	}
+	var spans []riskSpan
+
+	var b strings.Builder
+	b.Grow(len(s))
+	n := 0 // runes written to b
+	var hidden []rune
+	prev := rune(-1)
+	for i, r := range s {
+		kind := invisibleKind(r)
+		if kind == "" || keepJoiner(r, prev, s[i+utf8.RuneLen(r):]) {
+			if len(hidden) > 0 {
+				spans = append(spans, riskSpan{Rule: "unicode_tags", Start: n, Text: string(hidden)})
+				hidden = hidden[:0]
+			}
+			b.WriteRune(r)
+			n++
+			prev = r
+			continue
+		}
+		sc.found[kind] = invisibleWeights[kind]
+		if kind == "unicode_tags" {
+			// Tag characters mirror ASCII; decode them so the hidden
+			// message shows up in the span.
+			if t := r - 0xE0000; t >= 0x20 && t < 0x7F {
+				hidden = append(hidden, t)
+			}
+			continue
+		}
+		if len(spans) == 0 || spans[len(spans)-1].Rule != kind || spans[len(spans)-1].Start != n {
+			spans = append(spans, riskSpan{Rule: kind, Start: n})
+		}
+		spans[len(spans)-1].Text += fmt.Sprintf("U+%04X ", r)
+	}
+	if len(hidden) > 0 {
+		spans = append(spans, riskSpan{Rule: "unicode_tags", Start: n, Text: string(hidden)})
+	}
+	for i := range spans {
+		spans[i].Text = strings.TrimSpace(spans[i].Text)
+	}
+	clean := b.String()
+
+	for _, rule := range injectionRules {
+		for _, loc := range rule.re.FindAllStringIndex(clean, -1) {
+			sc.found[rule.name] = rule.weight
+			spans = append(spans, riskSpan{
+				Rule:  rule.name,
+				Start: utf8.RuneCountInString(clean[:loc[0]]),
+				Text:  strings.TrimSpace(truncateRunes(clean[loc[0]:loc[1]], maxRiskSpanText)),
+			})
+		}
+	}
+
+	slices.SortStableFunc(spans, func(a, b riskSpan) int { return cmp.Compare(a.Start, b.Start) })
+	for i := range spans {
+		spans[i].Field = field
+	}
+	sc.spans = append(sc.spans, spans...)
+	return clean
+}
+
+// result returns the combined risk score and the spans of every screened
+// field, in screening order.
+func (sc *screener) result() (float64, []riskSpan) {
+	// Independent signals combine like probabilities, so no number of weak
+	// ones reaches 1.
+	safe := 1.0
+	for _, w := range sc.found {
+		safe *= 1 - w
+	}
+	score := math.Round((1-safe)*100) / 100
+	spans := sc.spans
+	if len(spans) > maxRiskSpans {
+		spans = spans[:maxRiskSpans]
+	}
+	return score, spans
+}
+
+// keepJoiner reports whether a zero-width (non-)joiner is part of ordinary
+// text: emoji sequences and scripts that need it sit between two non-ASCII
+// characters.
+func keepJoiner(r, prev rune, rest string) bool {
+	if r != 0x200C && r != 0x200D {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(rest)
+	return prev >= utf8.RuneSelf && next >= utf8.RuneSelf && next != utf8.RuneError && invisibleKind(next) == ""
+}
+
+// riskLine is the text-mode warning for content that scored at least 0.5,
+// naming the rules that matched.
+func riskLine(score float64, spans []riskSpan) string {
+	if score < 0.5 {
+		return ""
+	}
+	var rules []string
+	for _, s := range spans {
+		if !slices.Contains(rules, s.Rule) {
+			rules = append(rules, s.Rule)
+		}
+	}
+	return fmt.Sprintf("> Warning: possible prompt injection (risk %.2f: %s)", score, strings.Join(rules, ", "))
+}
+
+var reUntrustedMarker = regexp.MustCompile(`(?i)<(/?untrusted-content)`)
+
+// wrapUntrusted delimits content as untrusted data from source. Marker tags
+// inside the content are escaped so a page cannot close the block early.
+func wrapUntrusted(content, source string) string {
+	if content == "" {
+		return ""
+	}
+	content = reUntrustedMarker.ReplaceAllString(content, "&lt;$1")
+	return fmt.Sprintf("<untrusted-content source=%q>\n%s\n</untrusted-content>", source, content)
+}
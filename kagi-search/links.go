@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Link classifications reported in pageLink.
+const (
+	linkInternal = "internal"
+	linkExternal = "external"
+	linkBody     = "body"
+	linkNav      = "nav"
+)
+
+// pageLink is an outbound link of a page. Scope is linkInternal (same host,
+// ignoring "www.") or linkExternal; Section is linkBody when the link is part
+// of the readable article and linkNav for navigation chrome.
+type pageLink struct {
+	URL     string `json:"url"`
+	Text    string `json:"text,omitempty"`
+	Scope   string `json:"scope"`
+	Section string `json:"section"`
+}
+
+// linkOptions enables link extraction and filters the result.
+type linkOptions struct {
+	sameHost bool           // keep internal links only
+	match    *regexp.Regexp // keep links whose URL matches; nil keeps all
+}
+
+// extractLinks returns the page's http(s) links in document order, resolved
+// against base, canonicalized and deduplicated like search results. Links
+// that also appear in body (the readability article node) are classified as
+// body links; when body is nil, links outside <nav>, <header>, <footer> and
+// <aside> are.
+func extractLinks(htmlDoc string, body *html.Node, base *url.URL, opts linkOptions) []pageLink {
+	doc, err := html.Parse(strings.NewReader(htmlDoc))
+	if err != nil {
+		return nil
+	}
+
+	inBody := make(map[string]int)
+	if body != nil {
+		walkAnchors(body, false, func(a *html.Node, _ bool) {
+			if u := resolveLink(getAttr(a, "href"), base); u != "" {
+				inBody[u]++
+			}
+		})
+	}
+
+	self := dedupeKey(base.String())
+	var links []pageLink
+	index := make(map[string]int)
+	walkAnchors(doc, false, func(a *html.Node, chrome bool) {
+		u := resolveLink(getAttr(a, "href"), base)
+		if u == "" || dedupeKey(u) == self {
+			return
+		}
+		section := linkBody
+		switch {
+		case body != nil && inBody[u] > 0:
+			inBody[u]--
+		case body != nil || chrome:
+			section = linkNav
+		}
+
+		key := dedupeKey(u)
+		if i, seen := index[key]; seen {
+			if section == linkBody {
+				links[i].Section = linkBody
+			}
+			if links[i].Text == "" {
+				links[i].Text = anchorText(a)
+			}
+			return
+		}
+
+		scope := linkExternal
+		if target, err := url.Parse(u); err == nil && sameSite(target, base) {
+			scope = linkInternal
+		}
+		if opts.sameHost && scope != linkInternal {
+			return
+		}
+		if opts.match != nil && !opts.match.MatchString(u) {
+			return
+		}
+		index[key] = len(links)
+		links = append(links, pageLink{URL: u, Text: anchorText(a), Scope: scope, Section: section})
+	})
+	return links
+}
+
+// walkAnchors calls fn for every <a> element below n. chrome reports whether
+// the anchor sits inside navigation chrome (<nav>, <header>, <footer>,
+// <aside>).
+func walkAnchors(n *html.Node, chrome bool, fn func(a *html.Node, chrome bool)) {
+	if n.Type == html.ElementNode {
+		switch n.DataAtom {
+		case atom.A:
+			fn(n, chrome)
+		case atom.Nav, atom.Header, atom.Footer, atom.Aside:
+			chrome = true
+		case atom.Script, atom.Style, atom.Noscript, atom.Template:
+			return
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkAnchors(c, chrome, fn)
+	}
+}
+
+// resolveLink returns href as an absolute, canonicalized http(s) URL, or ""
+// for fragments, non-web schemes and unparseable values.
+func resolveLink(href string, base *url.URL) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return ""
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return canonicalizeURL(u.String())
+}
+
+// anchorText returns the link's visible text, falling back to its title or
+// the alt text of an image inside it.
+func anchorText(a *html.Node) string {
+	if t := cleanLine(textContent(a)); t != "" {
+		return t
+	}
+	if t := cleanLine(getAttr(a, "title")); t != "" {
+		return t
+	}
+	if img := findElement(a, atom.Img); img != nil {
+		return cleanLine(getAttr(img, "alt"))
+	}
+	return ""
+}
+
+func sameSite(a, b *url.URL) bool {
+	return strings.TrimPrefix(strings.ToLower(a.Hostname()), "www.") ==
+		strings.TrimPrefix(strings.ToLower(b.Hostname()), "www.")
+}
+
+// printLinks prints links grouped by section for text output.
+func printLinks(links []pageLink) {
+	for _, section := range []string{linkBody, linkNav} {
+		var group []pageLink
+		for _, l := range links {
+			if l.Section == section {
+				group = append(group, l)
+			}
+		}
+		if len(group) == 0 {
+			continue
+		}
+		label := "Body links"
+		if section == linkNav {
+			label = "Navigation links"
+		}
+		fmt.Printf("%s (%d):\n", label, len(group))
+		for _, l := range group {
+			text := l.Text
+			if text == "" {
+				text = "(no text)"
+			}
+			fmt.Printf("- [%s] %s: %s\n", l.Scope, text, l.URL)
+		}
+		fmt.Println()
+	}
+}
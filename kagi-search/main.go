@@ -1,24 +1,30 @@
 package main
 
 import (
+	"bufio"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html"
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	readability "codeberg.org/readeck/go-readability/v2"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
 )
 
 var version = "dev" // injected via -ldflags "-X main.version=..."
@@ -29,6 +35,8 @@ const (
 	flagHelpShort    = "-h"
 	flagHelpLong     = "--help"
 	flagJSON         = "--json"
+
+	defaultContentConcurrency = 4
 )
 
 type apiMeta struct {
@@ -36,6 +44,8 @@ type apiMeta struct {
 	Node       string   `json:"node,omitempty"`
 	MS         int      `json:"ms,omitempty"`
 	APIBalance *float64 `json:"api_balance,omitempty"`
+	Attempts   int      `json:"attempts,omitempty"`   // API attempts, including retries
+	LatencyMS  int64    `json:"latency_ms,omitempty"` // total call time, including backoff
 }
 
 type apiThumbnail struct {
@@ -60,27 +70,60 @@ type kagiSearchResponse struct {
 }
 
 type searchResult struct {
-	Title        string        `json:"title"`
-	Link         string        `json:"link"`
-	Snippet      string        `json:"snippet"`
-	Published    string        `json:"published,omitempty"`
-	Thumbnail    *apiThumbnail `json:"thumbnail,omitempty"`
+	Title      string        `json:"title"`
+	Link       string        `json:"link"`
+	Snippet    string        `json:"snippet"`
+	Published  string        `json:"published,omitempty"`
+	Thumbnail  *apiThumbnail `json:"thumbnail,omitempty"`
+	Duplicates []string      `json:"duplicates,omitempty"`
+	fetchedContent
+}
+
+// fetchedContent holds the fields of a search result that come from fetching
+// its page with --content. They describe one fetch and are always copied
+// together, so screening results never get separated from the content.
+type fetchedContent struct {
+	CanonicalURL string        `json:"canonical_url,omitempty"`
 	Content      string        `json:"content,omitempty"`
 	ContentError string        `json:"content_error,omitempty"`
+	Metadata     *pageMetadata `json:"metadata,omitempty"`
+	WaitMS       int64         `json:"wait_ms,omitempty"`
+	Retries      int           `json:"retries,omitempty"`
+	HiddenChars  int           `json:"hidden_chars,omitempty"`
+	RiskScore    float64       `json:"risk_score,omitempty"`
+	RiskSpans    []riskSpan    `json:"risk_spans,omitempty"`
 }
 
 type searchOutput struct {
 	Query           string         `json:"query"`
 	Meta            apiMeta        `json:"meta"`
+	Cached          bool           `json:"cached"`
+	CachedAt        string         `json:"cached_at,omitempty"`
 	Results         []searchResult `json:"results"`
 	RelatedSearches []string       `json:"related_searches,omitempty"`
 }
 
 type contentOutput struct {
-	URL     string `json:"url"`
-	Title   string `json:"title,omitempty"`
-	Content string `json:"content,omitempty"`
-	Error   string `json:"error,omitempty"`
+	URL          string         `json:"url"`
+	CanonicalURL string         `json:"canonical_url,omitempty"`
+	Title        string         `json:"title,omitempty"`
+	Format       string         `json:"format,omitempty"`
+	ContentType  string         `json:"content_type,omitempty"`
+	Charset      string         `json:"charset,omitempty"`
+	Pages        int            `json:"pages,omitempty"`
+	Content      string         `json:"content,omitempty"`
+	Entries      []feedEntry    `json:"entries,omitempty"`
+	Metadata     *pageMetadata  `json:"metadata,omitempty"`
+	Links        []pageLink     `json:"links,omitempty"`
+	WaitMS       int64          `json:"wait_ms,omitempty"`
+	Retries      int            `json:"retries,omitempty"`
+	TotalChars   int            `json:"total_chars,omitempty"`
+	TotalChunks  int            `json:"total_chunks,omitempty"`
+	Chunks       []contentChunk `json:"chunks,omitempty"`
+	HiddenChars  int            `json:"hidden_chars,omitempty"`
+	RiskScore    float64        `json:"risk_score,omitempty"`
+	RiskSpans    []riskSpan     `json:"risk_spans,omitempty"`
+	Error        *errorInfo     `json:"error,omitempty"`
 }
 
 type balanceCache struct {
@@ -90,19 +133,19 @@ type balanceCache struct {
 }
 
 var (
-	reComments = regexp.MustCompile(`(?is)<!--.*?-->`)
-	reNoise    = regexp.MustCompile(`(?is)<(?:script|style|noscript|svg|iframe|nav|header|footer|aside)[^>]*>.*?</(?:script|style|noscript|svg|iframe|nav|header|footer|aside)>`)
-	reBlocks   = regexp.MustCompile(`(?is)</?(p|div|section|article|main|h[1-6]|li|ul|ol|blockquote|pre|tr|table|hr|br)[^>]*>`)
 	reTags     = regexp.MustCompile(`(?is)<[^>]+>`)
 	reMultiNL  = regexp.MustCompile(`\n{3,}`)
 	reTitle    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	reLinkTag  = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	reRelCanon = regexp.MustCompile(`(?i)\brel\s*=\s*["']?canonical\b`)
+	reHref     = regexp.MustCompile(`(?i)\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
 )
 
 func main() {
 	args := os.Args[1:]
 	if len(args) == 0 {
 		printGeneralUsage()
-		os.Exit(1)
+		os.Exit(exitCodes[kindUsage])
 	}
 
 	var err error
@@ -113,126 +156,203 @@ func main() {
 		err = runSearch(args[1:])
 	case "content":
 		err = runContent(args[1:])
+	case "crawl":
+		err = runCrawl(args[1:])
+	case "sitemap":
+		err = runSitemap(args[1:])
 	case "balance":
 		err = runBalance(args[1:])
+	case "batch":
+		err = runBatch(args[1:])
+	case "cache":
+		err = runCache(args[1:])
 	default:
 		// Convenience: allow calling binary directly without subcommand.
 		err = runSearch(args)
 	}
 
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+		exitWithError(err, slices.Contains(args, flagJSON))
 	}
 }
 
 func printGeneralUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  kagi-search search <query> [-n <num>] [--content] [--json]")
-	fmt.Println("  kagi-search content <url> [--json]")
+	fmt.Println("  kagi-search content <url>... [--format text|markdown|html] [--json|--stream]")
+	fmt.Println("  kagi-search crawl <url> [--depth <num>] [--max-pages <num>]")
+	fmt.Println("  kagi-search sitemap <url> [--prefix <path>] [--since <date>] [--content]")
+	fmt.Println("  kagi-search batch [file|-] [-n <num>] [--content] [--concurrency <num>]")
 	fmt.Println("  kagi-search balance [--json]")
+	fmt.Println("  kagi-search cache <stats|clear> [--json]")
+}
+
+type searchOptions struct {
+	query              string   // positionals joined by spaces
+	positionals        []string // non-flag arguments
+	filters            queryFilters
+	limit              int
+	fetchContent       bool
+	jsonOut            bool
+	showBalance        bool
+	timeoutSec         int
+	maxContentChars    int
+	concurrency        int
+	contentDeadlineSec int
+	noCache            bool
+	refresh            bool
+	cacheTTL           time.Duration
+	respectRobots      bool
+	wrapUntrusted      bool
 }
 
 func runSearch(args []string) error {
-	limit := 10
-	fetchContent := false
-	jsonOut := false
-	showBalance := false
-	timeoutSec := 15
-	maxContentChars := 5000
+	opts, help, err := parseSearchArgs(args)
+	if help {
+		printSearchUsage()
+		return nil
+	}
+	if err != nil {
+		return usageError(err, printSearchUsage)
+	}
+
+	query, err := buildQuery(opts.query, opts.filters)
+	if err != nil {
+		return err
+	}
+	if query == "" {
+		return usageError(errors.New("query is required"), printSearchUsage)
+	}
+
+	apiKey := strings.TrimSpace(os.Getenv("KAGI_API_KEY"))
+	if apiKey == "" {
+		return missingKeyError()
+	}
+
+	client := newHTTPClient(time.Duration(opts.timeoutSec) * time.Second)
+	resp, hit, err := fetchSearchCached(client, apiKey, query, opts.limit, opts.cacheTTL, opts.noCache, opts.refresh)
+	if err != nil {
+		return err
+	}
+
+	out := newSearchOutput(query, resp)
+	if hit != nil {
+		out.Cached = true
+		out.CachedAt = hit.StoredAt.Format(time.RFC3339)
+	} else {
+		_ = saveBalanceCache(resp.Meta, "kagi-search")
+	}
+
+	out.Results = dedupeResults(out.Results)
+	if opts.fetchContent {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(opts.contentDeadlineSec)*time.Second)
+		fetchOpts := fetchOptions{
+			maxChars:      opts.maxContentChars,
+			robots:        newRobotsPolicy(opts.respectRobots, botUserAgent()),
+			wrapUntrusted: opts.wrapUntrusted,
+		}
+		fetchResultsContent(ctx, newSafeContentClient(client.Timeout), out.Results, fetchOpts, opts.concurrency)
+		cancel()
+		// Fetched pages may declare a <link rel=canonical> that reveals more duplicates.
+		out.Results = dedupeResults(out.Results)
+	}
+
+	if opts.jsonOut {
+		return writeJSON(out)
+	}
+
+	printSearchOutput(out, opts.fetchContent, opts.showBalance)
+	return nil
+}
+
+// parseSearchArgs parses the flags of the search and batch subcommands and
+// clamps numeric options to their supported ranges. help is true when
+// -h/--help was given.
+func parseSearchArgs(args []string) (opts searchOptions, help bool, err error) {
+	opts = searchOptions{
+		limit:              10,
+		timeoutSec:         15,
+		maxContentChars:    5000,
+		concurrency:        defaultContentConcurrency,
+		contentDeadlineSec: 45,
+		cacheTTL:           searchCacheTTL(),
+		respectRobots:      respectRobotsDefault(),
+	}
 
-	queryParts := make([]string, 0, len(args))
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 		switch arg {
 		case flagHelpShort, flagHelpLong:
-			printSearchUsage()
-			return nil
+			return opts, true, nil
 		case "--":
-			queryParts = append(queryParts, args[i+1:]...)
+			opts.positionals = append(opts.positionals, args[i+1:]...)
 			i = len(args)
-		case "-n":
+		case "-n", "--timeout", "--max-content-chars", "--concurrency", "--content-deadline", "--cache-ttl":
 			if i+1 >= len(args) {
-				printSearchUsage()
-				return errors.New("missing value for -n")
+				return opts, false, fmt.Errorf("missing value for %s", arg)
 			}
 			i++
 			n, err := strconv.Atoi(args[i])
 			if err != nil {
-				printSearchUsage()
-				return fmt.Errorf("invalid value for -n: %s", args[i])
-			}
-			limit = n
-		case "--content":
-			fetchContent = true
-		case flagJSON:
-			jsonOut = true
-		case "--show-balance":
-			showBalance = true
-		case "--timeout":
-			if i+1 >= len(args) {
-				printSearchUsage()
-				return errors.New("missing value for --timeout")
+				return opts, false, fmt.Errorf("invalid value for %s: %s", arg, args[i])
 			}
-			i++
-			n, err := strconv.Atoi(args[i])
-			if err != nil {
-				printSearchUsage()
-				return fmt.Errorf("invalid value for --timeout: %s", args[i])
+			switch arg {
+			case "-n":
+				opts.limit = n
+			case "--timeout":
+				opts.timeoutSec = n
+			case "--max-content-chars":
+				opts.maxContentChars = n
+			case "--concurrency":
+				opts.concurrency = n
+			case "--content-deadline":
+				opts.contentDeadlineSec = n
+			case "--cache-ttl":
+				if n < 0 {
+					return opts, false, fmt.Errorf("invalid value for --cache-ttl: %s", args[i])
+				}
+				opts.cacheTTL = time.Duration(n) * time.Second
 			}
-			timeoutSec = n
-		case "--max-content-chars":
+		case "--site", "--exclude-site", "--filetype", "--intitle", "--lens":
 			if i+1 >= len(args) {
-				printSearchUsage()
-				return errors.New("missing value for --max-content-chars")
+				return opts, false, fmt.Errorf("missing value for %s", arg)
 			}
 			i++
-			n, err := strconv.Atoi(args[i])
-			if err != nil {
-				printSearchUsage()
-				return fmt.Errorf("invalid value for --max-content-chars: %s", args[i])
-			}
-			maxContentChars = n
+			opts.filters.add(arg, args[i])
+		case "--content":
+			opts.fetchContent = true
+		case flagJSON:
+			opts.jsonOut = true
+		case "--show-balance":
+			opts.showBalance = true
+		case "--no-cache":
+			opts.noCache = true
+		case "--refresh":
+			opts.refresh = true
+		case "--respect-robots", "--ignore-robots":
+			opts.respectRobots = arg == "--respect-robots"
+		case "--wrap-untrusted":
+			opts.wrapUntrusted = true
 		default:
-			if strings.HasPrefix(arg, "-") {
-				printSearchUsage()
-				return fmt.Errorf("unknown option: %s", arg)
+			if strings.HasPrefix(arg, "-") && arg != "-" {
+				return opts, false, fmt.Errorf("unknown option: %s", arg)
 			}
-			queryParts = append(queryParts, arg)
+			opts.positionals = append(opts.positionals, arg)
 		}
 	}
 
-	query := strings.TrimSpace(strings.Join(queryParts, " "))
-	if query == "" {
-		printSearchUsage()
-		return errors.New("query is required")
-	}
-
-	apiKey := strings.TrimSpace(os.Getenv("KAGI_API_KEY"))
-	if apiKey == "" {
-		return errors.New("KAGI_API_KEY environment variable is required (https://kagi.com/settings/api)")
-	}
-
-	if limit < 1 {
-		limit = 1
-	}
-	if limit > 100 {
-		limit = 100
-	}
-	if timeoutSec < 1 {
-		timeoutSec = 1
-	}
-	if maxContentChars < 0 {
-		maxContentChars = 0
-	}
-
-	client := newHTTPClient(time.Duration(timeoutSec) * time.Second)
-	resp, err := fetchSearch(client, apiKey, query, limit)
-	if err != nil {
-		return err
-	}
-	_ = saveBalanceCache(resp.Meta, "kagi-search")
+	opts.query = strings.TrimSpace(strings.Join(opts.positionals, " "))
+	opts.limit = min(max(opts.limit, 1), 100)
+	opts.timeoutSec = max(opts.timeoutSec, 1)
+	opts.maxContentChars = max(opts.maxContentChars, 0)
+	opts.concurrency = min(max(opts.concurrency, 1), 16)
+	opts.contentDeadlineSec = max(opts.contentDeadlineSec, 1)
+	return opts, false, nil
+}
 
+// newSearchOutput converts a raw API response into the CLI's output shape,
+// splitting search results (t=0) from related searches (t=1).
+func newSearchOutput(query string, resp *kagiSearchResponse) searchOutput {
 	out := searchOutput{
 		Query:   query,
 		Meta:    resp.Meta,
@@ -253,32 +373,16 @@ func runSearch(args []string) error {
 			out.RelatedSearches = append(out.RelatedSearches, item.List...)
 		}
 	}
+	return out
+}
 
-	if fetchContent {
-		contentClient := newSafeContentClient(client.Timeout)
-		for i := range out.Results {
-			title, content, fetchErr := fetchPageContent(contentClient, out.Results[i].Link, maxContentChars)
-			if out.Results[i].Title == "" && title != "" {
-				out.Results[i].Title = title
-			}
-			if fetchErr != nil {
-				out.Results[i].ContentError = fetchErr.Error()
-				continue
-			}
-			out.Results[i].Content = content
-		}
-	}
-
-	if jsonOut {
-		return writeJSON(out)
-	}
-
+func printSearchOutput(out searchOutput, fetchContent, showBalance bool) {
 	if len(out.Results) == 0 {
 		fmt.Fprintln(os.Stderr, "No results found.")
 		if showBalance && out.Meta.APIBalance != nil {
 			fmt.Fprintf(os.Stderr, "[API Balance: $%.4f]\n", *out.Meta.APIBalance)
 		}
-		return nil
+		return
 	}
 
 	for i, r := range out.Results {
@@ -290,6 +394,12 @@ func runSearch(args []string) error {
 		}
 		fmt.Printf("Snippet: %s\n", r.Snippet)
 		if fetchContent {
+			if line := metadataLine(r.Metadata); line != "" {
+				fmt.Printf("Meta: %s\n", line)
+			}
+			if line := riskLine(r.RiskScore, r.RiskSpans); line != "" {
+				fmt.Println(line)
+			}
 			if r.Content != "" {
 				fmt.Printf("Content:\n%s\n", r.Content)
 			} else if r.ContentError != "" {
@@ -304,106 +414,190 @@ func runSearch(args []string) error {
 	if showBalance && out.Meta.APIBalance != nil {
 		fmt.Fprintf(os.Stderr, "[API Balance: $%.4f]\n", *out.Meta.APIBalance)
 	}
+}
 
-	return nil
+type contentOptions struct {
+	urls          []string
+	jsonOut       bool
+	timeoutSec    int
+	respectRobots bool
+	chunkSize     int // 0 disables chunking
+	chunkOverlap  int
+	chunk         int // 1-based chunk to return; 0 returns all
+	concurrency   int
+	stream        bool
+	fetch         fetchOptions
 }
 
 func runContent(args []string) error {
-	jsonOut := false
-	timeoutSec := 20
-	maxChars := 20000
+	opts, help, err := parseContentArgs(args)
+	if help {
+		printContentUsage()
+		return nil
+	}
+	if err != nil {
+		return usageError(err, printContentUsage)
+	}
+
+	urls := opts.urls
+	fromStdin := slices.Contains(urls, "-") || (len(urls) == 0 && stdinIsPiped())
+	if fromStdin {
+		urls = slices.DeleteFunc(urls, func(u string) bool { return u == "-" })
+		stdinURLs, err := readURLList(os.Stdin)
+		if err != nil {
+			return err
+		}
+		urls = append(urls, stdinURLs...)
+	}
+	if len(urls) == 0 {
+		return usageError(errors.New("url is required"), printContentUsage)
+	}
+
+	client := newSafeContentClient(time.Duration(opts.timeoutSec) * time.Second)
+	opts.fetch.robots = newRobotsPolicy(opts.respectRobots, botUserAgent())
+
+	// A single URL argument keeps the single-object output.
+	if len(urls) == 1 && !fromStdin && !opts.stream {
+		out, err := fetchContentRecord(context.Background(), client, urls[0], opts)
+		if err != nil {
+			return err
+		}
+		if opts.jsonOut {
+			return writeJSON(out)
+		}
+		printContentText(out, opts)
+		return nil
+	}
+
+	return runContentMulti(client, urls, opts)
+}
+
+// parseContentArgs parses the content subcommand's flags. help is true when
+// -h/--help was given.
+func parseContentArgs(args []string) (opts contentOptions, help bool, err error) {
+	opts = contentOptions{
+		timeoutSec:    20,
+		concurrency:   defaultContentConcurrency,
+		respectRobots: respectRobotsDefault(),
+		fetch: fetchOptions{
+			maxChars: 20000,
+			format:   formatText,
+		},
+	}
 
-	positionals := make([]string, 0, len(args))
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 		switch arg {
 		case flagHelpShort, flagHelpLong:
-			printContentUsage()
-			return nil
+			return opts, true, nil
 		case "--":
-			positionals = append(positionals, args[i+1:]...)
+			opts.urls = append(opts.urls, args[i+1:]...)
 			i = len(args)
 		case flagJSON:
-			jsonOut = true
-		case "--timeout":
+			opts.jsonOut = true
+		case "--respect-robots", "--ignore-robots":
+			opts.respectRobots = arg == "--respect-robots"
+		case "--stream":
+			opts.stream = true
+		case "--wrap-untrusted":
+			opts.fetch.wrapUntrusted = true
+		case "--keep-hidden":
+			opts.fetch.keepHidden = true
+		case "--links":
+			opts.fetch.links = cmp.Or(opts.fetch.links, &linkOptions{})
+		case "--links-same-host":
+			opts.fetch.links = cmp.Or(opts.fetch.links, &linkOptions{})
+			opts.fetch.links.sameHost = true
+		case "--links-match":
 			if i+1 >= len(args) {
-				printContentUsage()
-				return errors.New("missing value for --timeout")
+				return opts, false, errors.New("missing value for --links-match")
 			}
 			i++
-			n, err := strconv.Atoi(args[i])
+			re, err := regexp.Compile(args[i])
+			if err != nil {
+				return opts, false, fmt.Errorf("invalid --links-match pattern: %w", err)
+			}
+			opts.fetch.links = cmp.Or(opts.fetch.links, &linkOptions{})
+			opts.fetch.links.match = re
+		case "--selector", "--exclude-selector":
+			if i+1 >= len(args) {
+				return opts, false, fmt.Errorf("missing value for %s", arg)
+			}
+			i++
+			sel, err := parseSelector(arg, args[i])
 			if err != nil {
-				printContentUsage()
-				return fmt.Errorf("invalid value for --timeout: %s", args[i])
+				return opts, false, err
 			}
-			timeoutSec = n
-		case "--max-chars":
+			if arg == "--selector" {
+				opts.fetch.selector = sel
+			} else {
+				opts.fetch.exclude = sel
+			}
+		case "--timeout", "--max-chars", "--chunk-size", "--chunk-overlap", "--chunk", "--concurrency":
 			if i+1 >= len(args) {
-				printContentUsage()
-				return errors.New("missing value for --max-chars")
+				return opts, false, fmt.Errorf("missing value for %s", arg)
 			}
 			i++
 			n, err := strconv.Atoi(args[i])
+			if err != nil || (n < 0 && strings.HasPrefix(arg, "--chunk")) {
+				return opts, false, fmt.Errorf("invalid value for %s: %s", arg, args[i])
+			}
+			switch arg {
+			case "--timeout":
+				opts.timeoutSec = n
+			case "--max-chars":
+				opts.fetch.maxChars = n
+			case "--chunk-size":
+				opts.chunkSize = n
+			case "--chunk-overlap":
+				opts.chunkOverlap = n
+			case "--chunk":
+				opts.chunk = n
+			case "--concurrency":
+				opts.concurrency = n
+			}
+		case "--pages":
+			if i+1 >= len(args) {
+				return opts, false, errors.New("missing value for --pages")
+			}
+			i++
+			pages, err := parsePageRange(args[i])
 			if err != nil {
-				printContentUsage()
-				return fmt.Errorf("invalid value for --max-chars: %s", args[i])
+				return opts, false, err
+			}
+			opts.fetch.pages = pages
+		case "--format":
+			if i+1 >= len(args) {
+				return opts, false, errors.New("missing value for --format")
+			}
+			i++
+			switch f := strings.ToLower(args[i]); f {
+			case formatText, formatMarkdown, formatHTML:
+				opts.fetch.format = f
+			case "md":
+				opts.fetch.format = formatMarkdown
+			default:
+				return opts, false, fmt.Errorf("unknown format %q — valid: text, markdown, html", args[i])
 			}
-			maxChars = n
 		default:
-			if strings.HasPrefix(arg, "-") {
-				printContentUsage()
-				return fmt.Errorf("unknown option: %s", arg)
+			if strings.HasPrefix(arg, "-") && arg != "-" {
+				return opts, false, fmt.Errorf("unknown option: %s", arg)
 			}
-			positionals = append(positionals, arg)
-		}
-	}
-
-	if len(positionals) == 0 {
-		printContentUsage()
-		return errors.New("url is required")
-	}
-	if len(positionals) > 1 {
-		printContentUsage()
-		return errors.New("content accepts exactly one URL")
-	}
-
-	targetURL := strings.TrimSpace(positionals[0])
-	parsedURL, err := validateRemoteFetchURL(targetURL)
-	if err != nil {
-		return err
-	}
-	targetURL = parsedURL.String()
-	if timeoutSec < 1 {
-		timeoutSec = 1
-	}
-	if maxChars < 0 {
-		maxChars = 0
-	}
-
-	client := newSafeContentClient(time.Duration(timeoutSec) * time.Second)
-	title, content, err := fetchPageContent(client, targetURL, maxChars)
-
-	if jsonOut {
-		out := contentOutput{
-			URL:     targetURL,
-			Title:   title,
-			Content: content,
+			opts.urls = append(opts.urls, strings.TrimSpace(arg))
 		}
-		if err != nil {
-			out.Error = err.Error()
-		}
-		return writeJSON(out)
 	}
 
-	if err != nil {
-		return err
+	opts.timeoutSec = max(opts.timeoutSec, 1)
+	opts.fetch.maxChars = max(opts.fetch.maxChars, 0)
+	opts.concurrency = min(max(opts.concurrency, 1), 16)
+	if opts.chunk > 0 && opts.chunkSize == 0 {
+		opts.chunkSize = defaultChunkSize
 	}
-
-	if title != "" {
-		fmt.Printf("# %s\n\n", title)
+	if opts.chunkSize > 0 {
+		// Chunking pages through the whole text instead of truncating it.
+		opts.fetch.maxChars = 0
 	}
-	fmt.Println(content)
-	return nil
+	return opts, false, nil
 }
 
 func runBalance(args []string) error {
@@ -417,7 +611,7 @@ func runBalance(args []string) error {
 		case flagJSON:
 			jsonOut = true
 		default:
-			return fmt.Errorf("unknown option: %s", args[i])
+			return usageError(fmt.Errorf("unknown option: %s", args[i]), printBalanceUsage)
 		}
 	}
 
@@ -459,22 +653,67 @@ func printSearchUsage() {
 	fmt.Println("Options:")
 	fmt.Println("  -n <num>              Number of results (default: 10, max: 100)")
 	fmt.Println("  --content             Fetch readable page content")
+	fmt.Println("  --site <host>         Restrict to a site (repeatable; multiple are OR-ed)")
+	fmt.Println("  --exclude-site <host> Exclude a site (repeatable)")
+	fmt.Println("  --filetype <ext>      Restrict to a file type, e.g. pdf (repeatable)")
+	fmt.Println("  --intitle <text>      Require text in the page title (repeatable)")
+	fmt.Printf("  --lens <name>         Preset site group: %s\n", strings.Join(lensNames(), ", "))
 	fmt.Println("  --json                Emit JSON output")
 	fmt.Println("  --show-balance        Print API balance to stderr")
 	fmt.Println("  --timeout <sec>       HTTP timeout in seconds (default: 15)")
 	fmt.Println("  --max-content-chars   Max chars per fetched content (default: 5000)")
+	fmt.Println("  --no-cache            Neither read nor write the response cache")
+	fmt.Println("  --refresh             Bypass cached responses but store the new one")
+	fmt.Println("  --cache-ttl <sec>     Max age of a cached response (default: 3600, 0 disables lookups)")
+	fmt.Println("  --concurrency <num>   Parallel page fetches for --content (default: 4, max: 16)")
+	fmt.Println("  --content-deadline <sec>")
+	fmt.Println("                        Overall deadline for --content fetches (default: 45)")
+	fmt.Println("  --respect-robots      Honor robots.txt, X-Robots-Tag and meta robots for --content")
+	fmt.Println("  --wrap-untrusted      Wrap fetched content in <untrusted-content> markers")
+	fmt.Println("  --ignore-robots       Disable robots handling enabled by KAGI_RESPECT_ROBOTS")
 	fmt.Println()
 	fmt.Println("Environment:")
 	fmt.Println("  KAGI_API_KEY          Required. Your Kagi Search API key.")
+	fmt.Println("  KAGI_SEARCH_CACHE_TTL Default cache TTL in seconds (default: 3600)")
+	fmt.Println("  KAGI_RESPECT_ROBOTS   Set to 1 to honor robots directives by default")
+	fmt.Println("  KAGI_BOT_USER_AGENT   User-Agent sent when robots handling is enabled")
+	fmt.Println("  KAGI_CONTENT_ALLOW_CIDRS Addresses pages may be fetched from despite the SSRF guard")
+	fmt.Println("  KAGI_CONTENT_DENY_CIDRS  Extra addresses pages are never fetched from")
+	fmt.Println("  KAGI_CONTENT_PROXY    Proxy for page fetches: http://, https:// or socks5:// URL")
 }
 
 func printContentUsage() {
-	fmt.Println("Usage: kagi-search content <url> [--json]")
+	fmt.Println("Usage: kagi-search content <url>... [--format text|markdown|html] [--json|--stream]")
+	fmt.Println()
+	fmt.Println("URLs are read from the arguments and, with \"-\" or when piped, from stdin (one per line).")
 	fmt.Println()
 	fmt.Println("Options:")
-	fmt.Println("  --json                Emit JSON output")
+	fmt.Println("  --json                Emit JSON (an array when several URLs are given)")
+	fmt.Println("  --stream              Emit one NDJSON record per URL as soon as it is done")
+	fmt.Println("  --concurrency <num>   URLs fetched in parallel (default: 4, max: 16)")
 	fmt.Println("  --timeout <sec>       HTTP timeout in seconds (default: 20)")
 	fmt.Println("  --max-chars <num>     Max chars to output (default: 20000)")
+	fmt.Println("  --format <fmt>        Output format: text, markdown or html (default: text)")
+	fmt.Println("  --pages <range>       PDF pages to extract, e.g. 1-5 or 1,3,7- (default: all)")
+	fmt.Println("  --chunk-size <num>    Split content into chunks of at most num chars (disables --max-chars)")
+	fmt.Println("  --chunk-overlap <num> Chars repeated between consecutive chunks (default: 0)")
+	fmt.Println("  --chunk <k>           Return only chunk k (1-based; default chunk size: 4000)")
+	fmt.Println("  --selector <css>      Extract only elements matching the CSS selector (HTML)")
+	fmt.Println("  --exclude-selector <css> Drop matching HTML elements before extraction")
+	fmt.Println("  --links               List the page's outbound links instead of its content")
+	fmt.Println("  --links-same-host     Only links to the page's own host (implies --links)")
+	fmt.Println("  --links-match <re>    Only links whose URL matches the regex (implies --links)")
+	fmt.Println("  --respect-robots      Honor robots.txt, X-Robots-Tag and meta robots")
+	fmt.Println("  --wrap-untrusted      Wrap content in <untrusted-content> markers")
+	fmt.Println("  --keep-hidden         Keep hidden and off-screen HTML elements (debugging)")
+	fmt.Println("  --ignore-robots       Disable robots handling enabled by KAGI_RESPECT_ROBOTS")
+	fmt.Println()
+	fmt.Println("Environment:")
+	fmt.Println("  KAGI_RESPECT_ROBOTS   Set to 1 to honor robots directives by default")
+	fmt.Println("  KAGI_BOT_USER_AGENT   User-Agent sent when robots handling is enabled")
+	fmt.Println("  KAGI_CONTENT_ALLOW_CIDRS Addresses pages may be fetched from despite the SSRF guard")
+	fmt.Println("  KAGI_CONTENT_DENY_CIDRS  Extra addresses pages are never fetched from")
+	fmt.Println("  KAGI_CONTENT_PROXY    Proxy for page fetches: http://, https:// or socks5:// URL")
 }
 
 func printBalanceUsage() {
@@ -506,22 +745,32 @@ func newSafeContentClient(timeout time.Duration) *http.Client {
 		transport = &http.Transport{}
 	}
 	// Security: ignore proxy env vars for untrusted URL fetches to avoid bypassing
-	// local-IP protections through a forward proxy.
+	// local-IP protections through a forward proxy. KAGI_CONTENT_PROXY is used
+	// from the dialer instead, after the destination has been checked.
 	transport.Proxy = nil
 	transport.ForceAttemptHTTP2 = true
 
 	dialer := &net.Dialer{Timeout: 15 * time.Second, KeepAlive: 30 * time.Second}
+	proxyURL, proxyErr := loadContentProxy()
+	dial := newContentDialer(dialer, proxyURL)
 	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
 		host, port, err := net.SplitHostPort(address)
 		if err != nil {
 			return nil, err
 		}
+		if proxyErr != nil {
+			return nil, usageError(proxyErr, nil)
+		}
+		policy, err := loadIPPolicy()
+		if err != nil {
+			return nil, usageError(err, nil)
+		}
 
 		if ip := net.ParseIP(host); ip != nil {
-			if isBlockedIP(ip) {
-				return nil, fmt.Errorf("blocked private or local IP address: %s", ip)
+			if d := policy.checkIP(ip); d.blocked {
+				return nil, blockedIPError(host, d)
 			}
-			return dialer.DialContext(ctx, network, address)
+			return dial(ctx, network, address)
 		}
 
 		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
@@ -530,18 +779,26 @@ func newSafeContentClient(timeout time.Duration) *http.Client {
 		}
 
 		allowed := make([]string, 0, len(ips))
+		var denied error
 		for _, ipAddr := range ips {
-			if !isBlockedIP(ipAddr.IP) {
-				allowed = append(allowed, ipAddr.IP.String())
+			if d := policy.checkIP(ipAddr.IP); d.blocked {
+				if denied == nil {
+					denied = kindError(kindBlocked, fmt.Errorf("blocked host %q: resolves to %s, which matches %s", host, ipAddr.IP, d))
+				}
+				continue
 			}
+			allowed = append(allowed, ipAddr.IP.String())
 		}
 		if len(allowed) == 0 {
-			return nil, fmt.Errorf("blocked host %q: resolves to private or local IP", host)
+			if denied == nil {
+				denied = fmt.Errorf("no addresses found for host %q", host)
+			}
+			return nil, denied
 		}
 
 		var lastErr error
 		for _, ip := range allowed {
-			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
 			if err == nil {
 				return conn, nil
 			}
@@ -553,55 +810,58 @@ func newSafeContentClient(timeout time.Duration) *http.Client {
 		return nil, fmt.Errorf("failed to dial host %q", host)
 	}
 
+	// The timeout is enforced per attempt by politeTransport, so waiting for a
+	// busy host does not eat into it.
 	client := &http.Client{
-		Timeout:   timeout,
-		Transport: transport,
+		Transport: newPoliteTransport(transport, timeout),
 	}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+	client.CheckRedirect = contentRedirectPolicy(client)
+	return client
+}
+
+// contentRedirectPolicy returns the CheckRedirect of content clients: every
+// hop is validated like the original URL and, when a robots policy is
+// attached to the request, checked against robots.txt before it is fetched.
+func contentRedirectPolicy(client *http.Client) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
 		if len(via) >= 10 {
 			return errors.New("stopped after 10 redirects")
 		}
-		_, err := validateRemoteFetchURL(req.URL.String())
-		return err
+		if _, err := validateRemoteFetchURL(req.URL.String()); err != nil {
+			return err
+		}
+		if robots := robotsPolicyFrom(req.Context()); robots != nil {
+			return robots.checkURL(req.Context(), client, req.URL)
+		}
+		return nil
 	}
-	return client
 }
 
 func validateRemoteFetchURL(rawURL string) (*url.URL, error) {
 	u, err := url.ParseRequestURI(strings.TrimSpace(rawURL))
 	if err != nil {
-		return nil, fmt.Errorf("invalid URL: %w", err)
+		return nil, usageError(fmt.Errorf("invalid URL: %w", err), nil)
 	}
 	if u.Scheme != "http" && u.Scheme != "https" {
-		return nil, fmt.Errorf("invalid URL scheme %q (only http/https are allowed)", u.Scheme)
+		return nil, usageError(fmt.Errorf("invalid URL scheme %q (only http/https are allowed)", u.Scheme), nil)
 	}
 	host := u.Hostname()
 	if host == "" {
-		return nil, errors.New("invalid URL: missing hostname")
+		return nil, usageError(errors.New("invalid URL: missing hostname"), nil)
 	}
-	if ip := net.ParseIP(host); ip != nil && isBlockedIP(ip) {
-		return nil, fmt.Errorf("blocked private or local IP address: %s", ip)
-	}
-	return u, nil
-}
-
-func isBlockedIP(ip net.IP) bool {
-	if ip == nil {
-		return true
+	policy, err := loadIPPolicy()
+	if err != nil {
+		return nil, usageError(err, nil)
 	}
-	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() {
-		return true
+	if _, err := loadContentProxy(); err != nil {
+		return nil, usageError(err, nil)
 	}
-	if ip4 := ip.To4(); ip4 != nil {
-		// Shared/reserved IPv4 ranges that should never be fetched from untrusted input.
-		if ip4[0] == 0 || ip4[0] >= 224 {
-			return true
-		}
-		if ip4[0] == 100 && ip4[1] >= 64 && ip4[1] <= 127 {
-			return true // 100.64.0.0/10
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if d := policy.check(addr); d.blocked {
+			return nil, blockedIPError(host, d)
 		}
 	}
-	return false
+	return u, nil
 }
 
 func fetchSearch(client *http.Client, apiKey, query string, limit int) (*kagiSearchResponse, error) {
@@ -609,92 +869,252 @@ func fetchSearch(client *http.Client, apiKey, query string, limit int) (*kagiSea
 	params.Set("q", query)
 	params.Set("limit", strconv.Itoa(limit))
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, kagiSearchURL+"?"+params.Encode(), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bot "+apiKey)
-	req.Header.Set("User-Agent", defaultUserAgent)
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	status, body, call, err := doAPIRequest(client, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, kagiSearchURL+"?"+params.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bot "+apiKey)
+		req.Header.Set("User-Agent", defaultUserAgent)
+		req.Header.Set("Accept", "application/json")
+		return req, nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, call.annotate(err)
 	}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		text := strings.TrimSpace(string(body))
-		if len(text) > 500 {
-			text = text[:500] + "..."
-		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, text)
+	if status < 200 || status >= 300 {
+		return nil, call.annotate(apiError(status, body))
 	}
 
 	var out kagiSearchResponse
 	if err := json.Unmarshal(body, &out); err != nil {
 		return nil, err
 	}
+	out.Meta.Attempts = call.attempts
+	out.Meta.LatencyMS = call.latency.Milliseconds()
 	return &out, nil
 }
 
-func fetchPageContent(client *http.Client, targetURL string, maxChars int) (title string, content string, err error) {
+// fetchResultsContent fills in Content (or ContentError) for each result using
+// a bounded pool of workers. Results are updated in place, so their order is
+// preserved. Pages still pending when ctx expires get a deadline error.
+func fetchResultsContent(ctx context.Context, client *http.Client, results []searchResult, opts fetchOptions, concurrency int) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(results)) {
+		wg.Go(func() {
+			for i := range jobs {
+				r := &results[i]
+				if ctx.Err() != nil {
+					r.ContentError = "content fetch deadline exceeded"
+					continue
+				}
+				page, err := fetchPageContent(ctx, client, r.Link, opts)
+				if r.Title == "" && page.Title != "" {
+					r.Title = page.Title
+				}
+				r.CanonicalURL = page.CanonicalURL
+				r.WaitMS, r.Retries = page.WaitMS, page.Retries
+				if err != nil {
+					if ctx.Err() != nil {
+						r.ContentError = "content fetch deadline exceeded"
+					} else {
+						r.ContentError = err.Error()
+					}
+					continue
+				}
+				r.Content = page.Content
+				r.Metadata = page.Metadata.orNil()
+				r.HiddenChars = page.HiddenChars
+				r.RiskScore, r.RiskSpans = page.RiskScore, page.RiskSpans
+			}
+		})
+	}
+	for i := range results {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// fetchOptions controls how fetchPageContent extracts a page.
+type fetchOptions struct {
+	maxChars int
+	format   string           // formatText (default), formatMarkdown or formatHTML
+	pages    pageRange        // PDF pages to extract; empty means all
+	robots   *robotsPolicy    // nil unless robots handling is enabled
+	links    *linkOptions     // nil unless outbound links are requested
+	selector cascadia.Matcher // extract only matching HTML elements
+	exclude  cascadia.Matcher // HTML elements dropped before extraction
+	// wrapUntrusted delimits the content with untrusted-content markers.
+	wrapUntrusted bool
+	keepHidden    bool // skip removeHidden, for debugging
+}
+
+// pageContent is the result of fetching and extracting a single page.
+type pageContent struct {
+	Title        string
+	Content      string
+	CanonicalURL string
+	ContentType  string      // one of the content* kinds, e.g. contentHTML
+	Charset      string      // charset the body was decoded from; empty for PDFs
+	Pages        int         // total page count, for PDFs
+	Entries      []feedEntry // parsed entries, for feeds
+	WaitMS       int64       // time spent waiting on per-host limits and Retry-After
+	Retries      int         // 429/503 responses retried
+	Metadata     pageMetadata
+	Links        []pageLink // outbound links, with fetchOptions.links
+	HiddenChars  int        // text characters in hidden elements removed before extraction
+	RiskScore    float64    // prompt-injection risk found by screenPage
+	RiskSpans    []riskSpan
+}
+
+func fetchPageContent(ctx context.Context, client *http.Client, targetURL string, opts fetchOptions) (page pageContent, err error) {
+	ctx, wait := withFetchWait(ctx)
+	defer func() {
+		page.WaitMS, page.Retries = wait.snapshot()
+	}()
+
 	parsedURL, err := validateRemoteFetchURL(targetURL)
 	if err != nil {
-		return "", "", err
+		return page, err
 	}
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, parsedURL.String(), nil)
+	userAgent := defaultUserAgent
+	if opts.robots != nil {
+		if err := opts.robots.checkURL(ctx, client, parsedURL); err != nil {
+			return page, err
+		}
+		userAgent = opts.robots.userAgent
+		// Redirect targets are checked by the client's CheckRedirect.
+		ctx = withRobotsPolicy(ctx, opts.robots)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsedURL.String(), nil)
 	if err != nil {
-		return "", "", err
+		return page, err
 	}
-	req.Header.Set("User-Agent", defaultUserAgent)
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,application/pdf;q=0.9,application/json;q=0.9,application/rss+xml;q=0.9,application/atom+xml;q=0.9,text/plain;q=0.8,*/*;q=0.7")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", "", err
+		return page, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", "", fmt.Errorf("HTTP %d", resp.StatusCode)
+		return page, fetchError(resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode))
 	}
 
-	body, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	// Sniff the start of the body too, since PDFs are often served as
+	// application/octet-stream and need the larger limit.
+	contentType := resp.Header.Get("Content-Type")
+	br := bufio.NewReader(resp.Body)
+	head, _ := br.Peek(1024)
+	limit := int64(8 << 20)
+	if isPDF(contentType, head) {
+		limit = maxPDFBytes
+	}
+	body, err := io.ReadAll(io.LimitReader(br, limit))
 	if err != nil {
-		return "", "", err
+		return page, err
 	}
 
-	htmlDoc := string(body)
+	if opts.robots != nil {
+		if err := opts.robots.checkResponse(resp.Header, body); err != nil {
+			return page, err
+		}
+	}
 
-	title, content = tryReadability(htmlDoc, parsedURL.String())
-	if title == "" {
-		title = extractTitle(htmlDoc)
+	page, err = extractDocument(body, contentType, resp.Request.URL, opts)
+	if err != nil {
+		return page, fetchError(0, err)
 	}
-	if content == "" {
-		content = extractReadableText(htmlDoc)
+
+	if strings.TrimSpace(page.Content) == "" && len(page.Links) == 0 {
+		page.Content = ""
+		screenPage(&page)
+		if page.HiddenChars > 0 {
+			return page, fetchError(0, fmt.Errorf("could not extract readable content (%d chars of hidden text removed; try --keep-hidden)", page.HiddenChars))
+		}
+		return page, fetchError(0, errors.New("could not extract readable content"))
 	}
 
-	if strings.TrimSpace(content) == "" {
-		return title, "", errors.New("could not extract readable content")
+	switch page.ContentType {
+	case contentHTML, contentPDF, contentText:
+		text := page.Content
+		if opts.format == formatHTML && page.ContentType == contentHTML {
+			text = reTags.ReplaceAllString(text, " ")
+		}
+		setSizeMetadata(&page.Metadata, text)
 	}
 
-	if maxChars > 0 {
-		content = truncateRunes(content, maxChars)
+	if opts.maxChars > 0 {
+		page.Content = truncateRunes(page.Content, opts.maxChars)
+	}
+	screenPage(&page)
+	if opts.wrapUntrusted {
+		page.Content = wrapUntrusted(page.Content, parsedURL.String())
 	}
-	return title, content, nil
+	return page, nil
 }
 
-// tryReadability attempts to extract title and content using the readability
-// algorithm. Returns empty strings if parsing fails at any step.
-func tryReadability(htmlDoc, targetURL string) (title, content string) {
+// extractHTML extracts the readable part of an HTML page in the requested
+// format, falling back to the regex-based extractors when readability fails.
+// With a selector, the matching elements are rendered instead of the
+// readability article. Hidden elements are removed first unless
+// opts.keepHidden is set.
+func extractHTML(htmlDoc string, pageURL *url.URL, opts fetchOptions) (pageContent, error) {
+	page := pageContent{
+		ContentType:  contentHTML,
+		CanonicalURL: extractCanonicalURL(htmlDoc, pageURL),
+	}
+	metaDoc := htmlDoc
+	if !opts.keepHidden {
+		htmlDoc, page.HiddenChars = removeHidden(htmlDoc)
+	}
+
+	format := opts.format
+	var body *html.Node
+	if opts.selector != nil || opts.exclude != nil {
+		var err error
+		htmlDoc, body, err = selectHTML(htmlDoc, opts.selector, opts.exclude)
+		if err != nil {
+			return page, err
+		}
+	}
+	if body != nil {
+		page.Content = renderSelection(body, format, pageURL)
+	} else {
+		page.Title, page.Content, page.Metadata, body = tryReadability(htmlDoc, pageURL.String(), format)
+	}
+	if page.Title == "" {
+		page.Title = extractTitle(metaDoc)
+	}
+	fillHTMLMetadata(&page.Metadata, metaDoc, pageURL)
+	if page.Content == "" && opts.selector == nil {
+		switch format {
+		case formatMarkdown:
+			page.Content = extractReadableMarkdown(htmlDoc, pageURL)
+		case formatHTML:
+			page.Content = extractReadableHTML(htmlDoc)
+		default:
+			page.Content = extractReadableText(htmlDoc)
+		}
+	}
+	if opts.links != nil {
+		page.Links = extractLinks(htmlDoc, body, pageURL, *opts.links)
+	}
+	return page, nil
+}
+
+// tryReadability attempts to extract title, content and article metadata
+// using the readability algorithm, rendering the article in the requested
+// format. body is the article's DOM node. Returns empty values if parsing
+// fails at any step.
+func tryReadability(htmlDoc, targetURL, format string) (title, content string, meta pageMetadata, body *html.Node) {
 	pageURL, err := url.Parse(targetURL)
 	if err != nil {
 		return
@@ -706,8 +1126,20 @@ func tryReadability(htmlDoc, targetURL string) (title, content string) {
 	if t := cleanLine(article.Title()); t != "" {
 		title = t
 	}
+	meta = articleMetadata(article)
+	body = article.Node
+	if format == formatMarkdown {
+		if article.Node != nil {
+			content = renderMarkdown(article.Node, pageURL)
+		}
+		return
+	}
 	var sb strings.Builder
-	if err := article.RenderText(&sb); err != nil {
+	render := article.RenderText
+	if format == formatHTML {
+		render = article.RenderHTML
+	}
+	if err := render(&sb); err != nil {
 		return
 	}
 	content = strings.TrimSpace(sb.String())
@@ -723,33 +1155,6 @@ func extractTitle(htmlDoc string) string {
 	return title
 }
 
-func extractReadableText(htmlDoc string) string {
-	s := reComments.ReplaceAllString(htmlDoc, " ")
-	s = reNoise.ReplaceAllString(s, "\n")
-	s = reBlocks.ReplaceAllString(s, "\n")
-	s = reTags.ReplaceAllString(s, " ")
-	s = html.UnescapeString(s)
-	s = strings.ReplaceAll(s, "\r", "")
-
-	lines := strings.Split(s, "\n")
-	cleaned := make([]string, 0, len(lines))
-	for _, line := range lines {
-		line = cleanLine(line)
-		if line == "" {
-			continue
-		}
-		cleaned = append(cleaned, line)
-	}
-
-	if len(cleaned) == 0 {
-		return ""
-	}
-
-	joined := strings.Join(cleaned, "\n\n")
-	joined = reMultiNL.ReplaceAllString(joined, "\n\n")
-	return strings.TrimSpace(joined)
-}
-
 func cleanLine(s string) string {
 	fields := strings.Fields(strings.TrimSpace(s))
 	return strings.Join(fields, " ")
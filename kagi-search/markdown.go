@@ -0,0 +1,441 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	formatText     = "text"
+	formatMarkdown = "markdown"
+	formatHTML     = "html"
+)
+
+// mdSkip lists elements whose content is never rendered.
+var mdSkip = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Button:   true,
+	atom.Select:   true,
+	atom.Input:    true,
+	atom.Textarea: true,
+	atom.Head:     true,
+}
+
+// mdBlock lists elements that start a new Markdown block.
+var mdBlock = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Body: true, atom.Details: true, atom.Dialog: true, atom.Dd: true, atom.Div: true,
+	atom.Dl: true, atom.Dt: true, atom.Fieldset: true, atom.Figcaption: true, atom.Figure: true,
+	atom.Footer: true, atom.Form: true, atom.H1: true, atom.H2: true, atom.H3: true,
+	atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true, atom.Hr: true,
+	atom.Html: true, atom.Li: true, atom.Main: true, atom.Nav: true, atom.Ol: true,
+	atom.P: true, atom.Pre: true, atom.Section: true, atom.Summary: true, atom.Table: true,
+	atom.Ul: true,
+}
+
+// mdRenderer converts an HTML DOM into Markdown, resolving links and image
+// sources against base.
+type mdRenderer struct {
+	base *url.URL
+}
+
+// renderMarkdown converts the DOM rooted at root into Markdown with ATX
+// headings, fenced code blocks, lists, tables and absolute links.
+func renderMarkdown(root *html.Node, base *url.URL) string {
+	r := mdRenderer{base: base}
+	out := strings.Join(r.blocks(root), "\n\n")
+	out = reMultiNL.ReplaceAllString(out, "\n\n")
+	return strings.TrimSpace(out)
+}
+
+// blocks renders the children of n as a list of Markdown blocks. Runs of
+// inline content between block elements become paragraphs.
+func (r mdRenderer) blocks(n *html.Node) []string {
+	var out []string
+	var inline strings.Builder
+	flush := func() {
+		if p := tidyInline(inline.String()); p != "" {
+			out = append(out, p)
+		}
+		inline.Reset()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && mdSkip[c.DataAtom] {
+			continue
+		}
+		if c.Type != html.ElementNode || !mdBlock[c.DataAtom] {
+			inline.WriteString(r.inline(c))
+			continue
+		}
+		flush()
+		b, ok := r.block(c)
+		switch {
+		case !ok:
+			out = append(out, r.blocks(c)...)
+		case b != "":
+			out = append(out, b)
+		}
+	}
+	flush()
+	return out
+}
+
+// block renders a single block element. ok is false for generic containers,
+// whose children are rendered by the caller instead.
+func (r mdRenderer) block(n *html.Node) (md string, ok bool) {
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text := tidyInline(r.inlineChildren(n))
+		if text == "" {
+			return "", true
+		}
+		level := int(n.Data[1] - '0')
+		return strings.Repeat("#", level) + " " + strings.ReplaceAll(text, "\n", " "), true
+	case atom.P, atom.Dt, atom.Dd, atom.Summary, atom.Figcaption:
+		if hasBlockChild(n) {
+			return strings.Join(r.blocks(n), "\n\n"), true
+		}
+		return tidyInline(r.inlineChildren(n)), true
+	case atom.Pre:
+		return r.codeBlock(n), true
+	case atom.Ul, atom.Ol:
+		return r.list(n), true
+	case atom.Blockquote:
+		inner := strings.Join(r.blocks(n), "\n\n")
+		if inner == "" {
+			return "", true
+		}
+		lines := strings.Split(inner, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return strings.Join(lines, "\n"), true
+	case atom.Table:
+		return r.table(n), true
+	case atom.Hr:
+		return "---", true
+	default:
+		return "", false
+	}
+}
+
+func (r mdRenderer) codeBlock(n *html.Node) string {
+	code := textContent(n)
+	code = strings.Trim(code, "\n")
+	if strings.TrimSpace(code) == "" {
+		return ""
+	}
+	lang := codeLanguage(n)
+	for c := n.FirstChild; c != nil && lang == ""; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Code {
+			lang = codeLanguage(c)
+		}
+	}
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence + lang + "\n" + code + "\n" + fence
+}
+
+func (r mdRenderer) list(n *html.Node) string {
+	ordered := n.DataAtom == atom.Ol
+	idx := 1
+	if start, err := strconv.Atoi(getAttr(n, "start")); err == nil {
+		idx = start
+	}
+
+	var items []string
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if ordered {
+			marker = strconv.Itoa(idx) + ". "
+			idx++
+		}
+		body := strings.Join(r.blocks(li), "\n")
+		if body == "" {
+			continue
+		}
+		indent := strings.Repeat(" ", len(marker))
+		lines := strings.Split(body, "\n")
+		for i := range lines {
+			if i == 0 {
+				lines[i] = marker + lines[i]
+			} else if lines[i] != "" {
+				lines[i] = indent + lines[i]
+			}
+		}
+		items = append(items, strings.Join(lines, "\n"))
+	}
+	return strings.Join(items, "\n")
+}
+
+func (r mdRenderer) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Tr:
+				var cells []string
+				for td := c.FirstChild; td != nil; td = td.NextSibling {
+					if td.Type == html.ElementNode && (td.DataAtom == atom.Td || td.DataAtom == atom.Th) {
+						cell := strings.ReplaceAll(tidyInline(r.inlineChildren(td)), "\n", " ")
+						cells = append(cells, strings.ReplaceAll(cell, "|", `\|`))
+					}
+				}
+				if len(cells) > 0 {
+					rows = append(rows, cells)
+				}
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			case atom.Table:
+				// Nested tables are flattened into the outer one.
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	cols := 0
+	for _, row := range rows {
+		cols = max(cols, len(row))
+	}
+	var sb strings.Builder
+	for i, row := range rows {
+		for len(row) < cols {
+			row = append(row, "")
+		}
+		sb.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			sb.WriteString("|" + strings.Repeat(" --- |", cols) + "\n")
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// inline renders a node in inline context.
+func (r mdRenderer) inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return n.Data
+	case html.ElementNode:
+	default:
+		return ""
+	}
+	if mdSkip[n.DataAtom] {
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		return "\n"
+	case atom.A:
+		text := tidyInline(r.inlineChildren(n))
+		href := r.resolve(getAttr(n, "href"))
+		if text == "" {
+			return ""
+		}
+		if href == "" {
+			return text
+		}
+		return fmt.Sprintf("[%s](%s)", text, href)
+	case atom.Img:
+		src := r.resolve(getAttr(n, "src"))
+		if src == "" {
+			return ""
+		}
+		return fmt.Sprintf("![%s](%s)", cleanLine(getAttr(n, "alt")), src)
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		code := strings.Join(strings.Fields(textContent(n)), " ")
+		if code == "" {
+			return ""
+		}
+		tick := "`"
+		for strings.Contains(code, tick) {
+			tick += "`"
+		}
+		return tick + code + tick
+	case atom.Strong, atom.B:
+		return wrapInline(r.inlineChildren(n), "**")
+	case atom.Em, atom.I:
+		return wrapInline(r.inlineChildren(n), "*")
+	case atom.Del, atom.S, atom.Strike:
+		return wrapInline(r.inlineChildren(n), "~~")
+	}
+	if mdBlock[n.DataAtom] {
+		// Block elements nested in inline context (e.g. <div> inside <a>).
+		return " " + r.inlineChildren(n) + " "
+	}
+	return r.inlineChildren(n)
+}
+
+func (r mdRenderer) inlineChildren(n *html.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(r.inline(c))
+	}
+	return sb.String()
+}
+
+// resolve returns ref as an absolute http(s) or mailto URL, or "" when it is
+// empty, a fragment-only link or uses another scheme (e.g. javascript:).
+func (r mdRenderer) resolve(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if r.base != nil {
+		u = r.base.ResolveReference(u)
+	}
+	switch u.Scheme {
+	case "http", "https", "mailto":
+		return u.String()
+	}
+	return ""
+}
+
+func wrapInline(s, marker string) string {
+	text := tidyInline(s)
+	if text == "" {
+		return ""
+	}
+	return marker + text + marker
+}
+
+// tidyInline collapses whitespace within each line of inline text while
+// keeping explicit line breaks from <br>.
+func tidyInline(s string) string {
+	lines := strings.Split(s, "\n")
+	out := lines[:0]
+	for _, line := range lines {
+		if line = cleanLine(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+func hasBlockChild(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && mdBlock[c.DataAtom] {
+			return true
+		}
+	}
+	return false
+}
+
+// codeLanguage extracts a language hint from class names such as
+// "language-go", "lang-go" or "highlight-source-go".
+func codeLanguage(n *html.Node) string {
+	for _, class := range strings.Fields(getAttr(n, "class")) {
+		for _, prefix := range []string{"language-", "lang-", "highlight-source-"} {
+			if lang, ok := strings.CutPrefix(class, prefix); ok && lang != "" {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Br {
+			sb.WriteString("\n")
+			continue
+		}
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
+
+// findElement returns the first element with the given atom in a depth-first
+// walk from n, or nil.
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// extractReadableMarkdown is the Markdown counterpart of extractReadableText
+// for pages readability cannot handle: comments and page chrome are pruned
+// from the parsed document, then the rest is rendered as Markdown.
+func extractReadableMarkdown(htmlDoc string, base *url.URL) string {
+	doc, err := html.Parse(strings.NewReader(htmlDoc))
+	if err != nil {
+		return ""
+	}
+	pruneNoise(doc)
+	root := findElement(doc, atom.Body)
+	if root == nil {
+		root = doc
+	}
+	return renderMarkdown(root, base)
+}
+
+// extractReadableHTML returns the fallback document's <body> with comments
+// and page chrome removed, for --format html.
+func extractReadableHTML(htmlDoc string) string {
+	doc, err := html.Parse(strings.NewReader(htmlDoc))
+	if err != nil {
+		return ""
+	}
+	pruneNoise(doc)
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&sb, c); err != nil {
+			return ""
+		}
+	}
+	if strings.TrimSpace(reTags.ReplaceAllString(sb.String(), "")) == "" {
+		return ""
+	}
+	return strings.TrimSpace(sb.String())
+}
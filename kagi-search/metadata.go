@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"codeberg.org/readeck/go-readability/v2"
+)
+
+// wordsPerMinute is the reading speed used for reading time estimates.
+const wordsPerMinute = 230
+
+var (
+	rePropertyAttr = regexp.MustCompile(`(?i)\b(?:property|itemprop)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	reHTMLLang     = regexp.MustCompile(`(?is)<html\s[^>]*\blang\s*=\s*["']?([A-Za-z]{2,3}(?:[-_][A-Za-z0-9]{2,8})*)`)
+	reJSONLD       = regexp.MustCompile(`(?is)<script[^>]*type\s*=\s*["']?application/ld\+json["']?[^>]*>(.*?)</script>`)
+)
+
+// pageMetadata describes a fetched page: authorship and freshness for HTML
+// articles, plus size estimates for any textual content.
+type pageMetadata struct {
+	Byline             string `json:"byline,omitempty"`
+	SiteName           string `json:"site_name,omitempty"`
+	PublishedTime      string `json:"published_time,omitempty"`
+	ModifiedTime       string `json:"modified_time,omitempty"`
+	Language           string `json:"language,omitempty"`
+	Excerpt            string `json:"excerpt,omitempty"`
+	Image              string `json:"image,omitempty"`
+	WordCount          int    `json:"word_count,omitempty"`
+	ReadingTimeMinutes int    `json:"reading_time_minutes,omitempty"`
+}
+
+// orNil returns m, or nil when it carries no information, so empty metadata
+// is omitted from JSON.
+func (m pageMetadata) orNil() *pageMetadata {
+	if m == (pageMetadata{}) {
+		return nil
+	}
+	return &m
+}
+
+// articleMetadata returns the metadata readability found for the article.
+func articleMetadata(article readability.Article) pageMetadata {
+	meta := pageMetadata{
+		Byline:   cleanLine(article.Byline()),
+		SiteName: cleanLine(article.SiteName()),
+		Language: cleanLine(article.Language()),
+		Excerpt:  cleanLine(article.Excerpt()),
+		Image:    strings.TrimSpace(article.ImageURL()),
+	}
+	if t, err := article.PublishedTime(); err == nil && !t.IsZero() {
+		meta.PublishedTime = t.UTC().Format(time.RFC3339)
+	}
+	if t, err := article.ModifiedTime(); err == nil && !t.IsZero() {
+		meta.ModifiedTime = t.UTC().Format(time.RFC3339)
+	}
+	return meta
+}
+
+// fillHTMLMetadata fills fields readability left empty from OpenGraph and
+// article <meta> tags, then JSON-LD, then plain <meta> tags and <html lang>.
+func fillHTMLMetadata(meta *pageMetadata, htmlDoc string, base *url.URL) {
+	tags := metaTags(htmlDoc)
+	ld := jsonLDArticle(htmlDoc)
+
+	fill := func(field *string, values ...string) {
+		if *field != "" {
+			return
+		}
+		for _, v := range values {
+			if v = cleanLine(html.UnescapeString(v)); v != "" {
+				*field = v
+				return
+			}
+		}
+	}
+
+	fill(&meta.Byline, ld.author, tags["author"], tags["article:author"], tags["twitter:creator"])
+	fill(&meta.SiteName, tags["og:site_name"], ld.publisher, tags["application-name"])
+	fill(&meta.PublishedTime, tags["article:published_time"], ld.datePublished, tags["datepublished"], tags["date"], tags["dc.date"], tags["dcterms.created"])
+	fill(&meta.ModifiedTime, tags["article:modified_time"], tags["og:updated_time"], ld.dateModified, tags["datemodified"], tags["dcterms.modified"])
+	fill(&meta.Excerpt, tags["og:description"], tags["description"], ld.description, tags["twitter:description"])
+	fill(&meta.Image, tags["og:image"], tags["og:image:url"], tags["twitter:image"], ld.image)
+	if m := reHTMLLang.FindStringSubmatch(htmlDoc); m != nil {
+		fill(&meta.Language, m[1])
+	}
+	fill(&meta.Language, ld.language, strings.ReplaceAll(tags["og:locale"], "_", "-"))
+
+	meta.PublishedTime = normalizeDate(meta.PublishedTime)
+	meta.ModifiedTime = normalizeDate(meta.ModifiedTime)
+	if strings.HasPrefix(meta.Byline, "http://") || strings.HasPrefix(meta.Byline, "https://") {
+		// article:author is often a profile URL rather than a name.
+		meta.Byline = ""
+	}
+	if meta.Image != "" && base != nil {
+		if ref, err := url.Parse(meta.Image); err == nil {
+			meta.Image = base.ResolveReference(ref).String()
+		}
+	}
+}
+
+// metaTags maps lowercase <meta> name/property/itemprop values to their
+// content. The first occurrence of a key wins.
+func metaTags(htmlDoc string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range reMetaTag.FindAllString(htmlDoc, -1) {
+		content := attrValue(reContentAttr, tag)
+		if content == "" {
+			continue
+		}
+		for _, key := range []string{attrValue(reNameAttr, tag), attrValue(rePropertyAttr, tag)} {
+			key = strings.ToLower(strings.TrimSpace(key))
+			if _, seen := tags[key]; key != "" && !seen {
+				tags[key] = content
+			}
+		}
+	}
+	return tags
+}
+
+type ldArticle struct {
+	author, publisher, datePublished, dateModified, description, image, language string
+}
+
+// jsonLDArticle returns the fields of the first JSON-LD object that looks
+// like an article (has a headline or datePublished), searching @graph arrays.
+func jsonLDArticle(htmlDoc string) ldArticle {
+	for _, m := range reJSONLD.FindAllStringSubmatch(htmlDoc, -1) {
+		var doc any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(m[1])), &doc); err != nil {
+			continue
+		}
+		if obj := findLDArticle(doc); obj != nil {
+			return ldArticle{
+				author:        ldName(obj["author"]),
+				publisher:     ldName(obj["publisher"]),
+				datePublished: ldString(obj["datePublished"]),
+				dateModified:  ldString(obj["dateModified"]),
+				description:   ldString(obj["description"]),
+				image:         ldURL(obj["image"]),
+				language:      ldString(obj["inLanguage"]),
+			}
+		}
+	}
+	return ldArticle{}
+}
+
+func findLDArticle(v any) map[string]any {
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			if obj := findLDArticle(item); obj != nil {
+				return obj
+			}
+		}
+	case map[string]any:
+		if _, ok := v["headline"]; ok {
+			return v
+		}
+		if _, ok := v["datePublished"]; ok {
+			return v
+		}
+		return findLDArticle(v["@graph"])
+	}
+	return nil
+}
+
+func ldString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []any:
+		if len(v) > 0 {
+			return ldString(v[0])
+		}
+	}
+	return ""
+}
+
+// ldName returns the name of a Person/Organization value, which may be a
+// plain string, an object or a list of either.
+func ldName(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]any:
+		return ldString(v["name"])
+	case []any:
+		names := make([]string, 0, len(v))
+		for _, item := range v {
+			if n := cleanLine(ldName(item)); n != "" {
+				names = append(names, n)
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+	return ""
+}
+
+// ldURL returns an ImageObject's URL; v may be a string, an object or a list.
+func ldURL(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]any:
+		return ldString(v["url"])
+	case []any:
+		if len(v) > 0 {
+			return ldURL(v[0])
+		}
+	}
+	return ""
+}
+
+// setSizeMetadata records the word count and reading time of text.
+func setSizeMetadata(meta *pageMetadata, text string) {
+	meta.WordCount = countWords(text)
+	if meta.WordCount > 0 {
+		meta.ReadingTimeMinutes = max(1, (meta.WordCount+wordsPerMinute-1)/wordsPerMinute)
+	}
+}
+
+// countWords counts whitespace-separated tokens containing a letter or digit,
+// so Markdown syntax and punctuation are not counted.
+func countWords(text string) int {
+	n := 0
+	for _, f := range strings.Fields(text) {
+		if strings.IndexFunc(f, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// metadataLine summarizes metadata for text output, e.g.
+// "By Jane Doe · Example News · published 2024-05-01 · 1200 words (~6 min)".
+func metadataLine(m *pageMetadata) string {
+	if m == nil {
+		return ""
+	}
+	parts := make([]string, 0, 5)
+	if m.Byline != "" {
+		parts = append(parts, "By "+m.Byline)
+	}
+	if m.SiteName != "" {
+		parts = append(parts, m.SiteName)
+	}
+	if m.PublishedTime != "" {
+		parts = append(parts, "published "+m.PublishedTime)
+	}
+	if m.ModifiedTime != "" && m.ModifiedTime != m.PublishedTime {
+		parts = append(parts, "updated "+m.ModifiedTime)
+	}
+	if m.WordCount > 0 {
+		parts = append(parts, fmt.Sprintf("%d words (~%d min)", m.WordCount, m.ReadingTimeMinutes))
+	}
+	return strings.Join(parts, " · ")
+}
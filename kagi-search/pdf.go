@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// maxPDFBytes bounds PDF downloads; papers and datasheets are routinely
+// larger than the 8 MiB allowed for HTML.
+const maxPDFBytes = 32 << 20
+
+// pageSpan is an inclusive 1-based page interval; to == 0 means "to the end".
+type pageSpan struct {
+	from, to int
+}
+
+// pageRange selects PDF pages; an empty range selects every page.
+type pageRange []pageSpan
+
+// parsePageRange parses specs such as "3", "1-5", "10-" or "1-3,7,9-".
+func parsePageRange(spec string) (pageRange, error) {
+	var out pageRange
+	for part := range strings.SplitSeq(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fromStr, toStr, isSpan := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(fromStr))
+		if err != nil || from < 1 {
+			return nil, fmt.Errorf("invalid page range %q", spec)
+		}
+		to := from
+		if isSpan {
+			to = 0
+			if s := strings.TrimSpace(toStr); s != "" {
+				to, err = strconv.Atoi(s)
+				if err != nil || to < from {
+					return nil, fmt.Errorf("invalid page range %q", spec)
+				}
+			}
+		}
+		out = append(out, pageSpan{from: from, to: to})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("invalid page range %q", spec)
+	}
+	return out, nil
+}
+
+func (pr pageRange) contains(page int) bool {
+	if len(pr) == 0 {
+		return true
+	}
+	for _, s := range pr {
+		if page >= s.from && (s.to == 0 || page <= s.to) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPDF reports whether the response is a PDF. A specific Content-Type is
+// trusted; when it is missing or generic (servers often send
+// application/octet-stream), the body must start with the "%PDF-" magic
+// bytes.
+func isPDF(contentType string, body []byte) bool {
+	if mediaType, ok := specificMediaType(contentType); ok {
+		return mediaType == "application/pdf" || mediaType == "application/x-pdf"
+	}
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+	body = bytes.TrimLeft(body, " \t\r\n\f\x00")
+	return bytes.HasPrefix(body, []byte("%PDF-"))
+}
+
+// extractPDF extracts the document title and the text of the selected pages.
+// Each page is introduced by a "--- Page N ---" marker so page boundaries
+// survive extraction. total is the document's page count.
+func extractPDF(body []byte, pages pageRange) (title, content string, total int, err error) {
+	// The PDF parser panics on some malformed inputs; treat that as an error.
+	defer func() {
+		if r := recover(); r != nil {
+			title, content = "", ""
+			err = fmt.Errorf("could not parse PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return "", "", 0, fmt.Errorf("could not parse PDF: %w", err)
+	}
+
+	title = cleanLine(reader.Trailer().Key("Info").Key("Title").Text())
+	total = reader.NumPage()
+
+	var sb strings.Builder
+	for i := 1; i <= total; i++ {
+		if !pages.contains(i) {
+			continue
+		}
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		// Font resource names are page-scoped, so let the parser load them per page.
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			continue
+		}
+		text = strings.TrimSpace(reMultiNL.ReplaceAllString(text, "\n\n"))
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "--- Page %d ---\n\n%s\n\n", i, text)
+	}
+
+	content = strings.TrimSpace(sb.String())
+	if content == "" {
+		if total > 0 && len(pages) > 0 {
+			return title, "", total, fmt.Errorf("no text found in the selected pages (document has %d pages)", total)
+		}
+		return title, "", total, errors.New("no extractable text in PDF (it may be scanned images)")
+	}
+	return title, content, total, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHostConcurrency = 2
+	defaultHostDelay       = 250 * time.Millisecond
+	maxFetchRetries        = 2
+	maxRetryAfter          = 30 * time.Second
+)
+
+// hostConcurrency returns the per-host request limit from
+// KAGI_CONTENT_HOST_CONCURRENCY, falling back to defaultHostConcurrency.
+func hostConcurrency() int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("KAGI_CONTENT_HOST_CONCURRENCY")))
+	if err != nil || n < 1 {
+		return defaultHostConcurrency
+	}
+	return n
+}
+
+// hostDelay returns the minimum gap between requests to one host from
+// KAGI_CONTENT_HOST_DELAY_MS, falling back to defaultHostDelay.
+func hostDelay() time.Duration {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("KAGI_CONTENT_HOST_DELAY_MS")))
+	if err != nil || n < 0 {
+		return defaultHostDelay
+	}
+	return time.Duration(n) * time.Millisecond
+}
+
+// fetchWait accumulates the time a fetch spent waiting on politeness limits
+// and Retry-After, across redirects and retries.
+type fetchWait struct {
+	mu      sync.Mutex
+	waited  time.Duration
+	retries int
+}
+
+type fetchWaitKey struct{}
+
+func withFetchWait(ctx context.Context) (context.Context, *fetchWait) {
+	w := &fetchWait{}
+	return context.WithValue(ctx, fetchWaitKey{}, w), w
+}
+
+func (w *fetchWait) add(d time.Duration, retry bool) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.waited += d
+	if retry {
+		w.retries++
+	}
+	w.mu.Unlock()
+}
+
+func (w *fetchWait) snapshot() (waitMS int64, retries int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.waited.Milliseconds(), w.retries
+}
+
+type noHostLimitKey struct{}
+
+// withoutHostLimit marks requests made while another request to the same host
+// may still hold a slot (robots.txt checks from CheckRedirect), so they skip
+// the per-host limits instead of waiting on themselves.
+func withoutHostLimit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noHostLimitKey{}, true)
+}
+
+// politeTransport limits concurrent requests per host, spaces request starts
+// to a host by a minimum delay and retries 429/503 responses after their
+// Retry-After. timeout applies to each attempt (until the body is closed), so
+// time spent queueing does not count against it.
+type politeTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+	perHost int
+	delay   time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	slots chan struct{}
+	next  time.Time // earliest start of the next request
+}
+
+func newPoliteTransport(base http.RoundTripper, timeout time.Duration) *politeTransport {
+	return &politeTransport{
+		base:    base,
+		timeout: timeout,
+		perHost: hostConcurrency(),
+		delay:   hostDelay(),
+		hosts:   make(map[string]*hostState),
+	}
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if bypass, _ := ctx.Value(noHostLimitKey{}).(bool); bypass {
+		return t.base.RoundTrip(req)
+	}
+	wait, _ := ctx.Value(fetchWaitKey{}).(*fetchWait)
+	h := t.host(strings.ToLower(req.URL.Host))
+
+	for attempt := 0; ; attempt++ {
+		queued := time.Now()
+		if err := t.acquire(ctx, h); err != nil {
+			return nil, err
+		}
+		wait.add(time.Since(queued), false)
+
+		resp, err := t.attempt(req, h)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			return resp, nil
+		}
+
+		delay := retryAfterDelay(resp.Header.Get("Retry-After"), attempt)
+		if attempt >= maxFetchRetries || delay > maxRetryAfter {
+			return resp, nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, nil
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+
+		// Back the whole host off, not just this request.
+		t.mu.Lock()
+		h.next = later(h.next, time.Now().Add(delay))
+		t.mu.Unlock()
+		wait.add(0, true)
+	}
+}
+
+func (t *politeTransport) host(name string) *hostState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.hosts[name]
+	if !ok {
+		h = &hostState{slots: make(chan struct{}, t.perHost)}
+		t.hosts[name] = h
+	}
+	return h
+}
+
+// acquire takes one of the host's slots and waits for its next start time.
+func (t *politeTransport) acquire(ctx context.Context, h *hostState) error {
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	t.mu.Lock()
+	start := later(h.next, time.Now())
+	h.next = start.Add(t.delay)
+	t.mu.Unlock()
+
+	if d := time.Until(start); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			<-h.slots
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// attempt sends one request. The host slot and the attempt timeout are held
+// until the response body is closed.
+func (t *politeTransport) attempt(req *http.Request, h *hostState) (*http.Response, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), t.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+	release := func() {
+		cancel()
+		<-h.slots
+	}
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// retryAfterDelay parses a Retry-After header (seconds or HTTP date). Without
+// one it backs off for 1s, 2s, ... per attempt.
+func retryAfterDelay(header string, attempt int) time.Duration {
+	if d, ok := parseRetryAfter(header); ok {
+		return d
+	}
+	return time.Duration(attempt+1) * time.Second
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
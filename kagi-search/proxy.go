@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// contentDialFunc dials a destination that has already passed the SSRF
+// policy, as an IP literal and port.
+type contentDialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// loadContentProxy reads KAGI_CONTENT_PROXY once per process. It returns a
+// nil URL when no proxy is configured.
+var loadContentProxy = sync.OnceValues(func() (*url.URL, error) {
+	raw := strings.TrimSpace(os.Getenv("KAGI_CONTENT_PROXY"))
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KAGI_CONTENT_PROXY: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	case "socks5h":
+		// Letting the proxy resolve hostnames would skip the local address
+		// check, so the proxy could reach blocked ranges.
+		return nil, errors.New("KAGI_CONTENT_PROXY: socks5h is not supported; use socks5 (destinations are resolved and checked locally)")
+	default:
+		return nil, fmt.Errorf("KAGI_CONTENT_PROXY: unsupported scheme %q (use http, https or socks5)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("KAGI_CONTENT_PROXY: missing proxy host")
+	}
+	if u.Port() == "" {
+		port := map[string]string{"http": "80", "https": "443", "socks5": "1080"}[u.Scheme]
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+})
+
+// newContentDialer returns the function that connects to validated content
+// destinations: directly, or through the proxy in proxyURL. A proxy is only
+// ever asked to connect to an IP address, never to a hostname it would
+// resolve itself.
+func newContentDialer(dialer *net.Dialer, proxyURL *url.URL) contentDialFunc {
+	if proxyURL == nil {
+		return dialer.DialContext
+	}
+	if proxyURL.Scheme == "socks5" {
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, dialer)
+		if err != nil {
+			return func(context.Context, string, string) (net.Conn, error) { return nil, err }
+		}
+		return socks.(proxy.ContextDialer).DialContext
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialHTTPConnect(ctx, dialer, proxyURL, address)
+	}
+}
+
+// dialHTTPConnect opens a tunnel to address through an HTTP(S) proxy with
+// CONNECT. Plain http:// pages are tunneled too, so the proxy never sees a
+// hostname to resolve.
+func dialHTTPConnect(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, address string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("connect to proxy: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("proxy TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s: %s", address, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a tunnel whose first bytes were read along with the
+// CONNECT response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// queryFilters holds structured search operators that are rendered into
+// Kagi query syntax by buildQuery, so callers never hand-write operators.
+type queryFilters struct {
+	Sites        []string `json:"site,omitempty"`
+	ExcludeSites []string `json:"exclude_site,omitempty"`
+	Filetypes    []string `json:"filetype,omitempty"`
+	InTitle      []string `json:"intitle,omitempty"`
+	Lens         string   `json:"lens,omitempty"`
+}
+
+// searchLenses are named shortcuts that expand to a group of site filters
+// (and optionally file types), similar to Kagi's built-in lenses.
+var searchLenses = map[string]queryFilters{
+	"academic": {Sites: []string{"arxiv.org", "semanticscholar.org", "acm.org", "ieee.org", "ncbi.nlm.nih.gov", "researchgate.net"}},
+	"docs":     {Sites: []string{"developer.mozilla.org", "docs.python.org", "pkg.go.dev", "docs.rs", "learn.microsoft.com", "readthedocs.io"}},
+	"forums":   {Sites: []string{"reddit.com", "news.ycombinator.com", "lobste.rs", "stackexchange.com", "discourse.org"}},
+	"code":     {Sites: []string{"github.com", "gitlab.com", "codeberg.org", "stackoverflow.com"}},
+	"pdf":      {Filetypes: []string{"pdf"}},
+}
+
+func (f *queryFilters) add(flag, value string) {
+	switch flag {
+	case "--site":
+		f.Sites = append(f.Sites, value)
+	case "--exclude-site":
+		f.ExcludeSites = append(f.ExcludeSites, value)
+	case "--filetype":
+		f.Filetypes = append(f.Filetypes, value)
+	case "--intitle":
+		f.InTitle = append(f.InTitle, value)
+	case "--lens":
+		f.Lens = value
+	}
+}
+
+// with returns f combined with g: list filters are concatenated and g's lens,
+// if set, replaces f's.
+func (f queryFilters) with(g queryFilters) queryFilters {
+	return queryFilters{
+		Sites:        append(slices.Clip(f.Sites), g.Sites...),
+		ExcludeSites: append(slices.Clip(f.ExcludeSites), g.ExcludeSites...),
+		Filetypes:    append(slices.Clip(f.Filetypes), g.Filetypes...),
+		InTitle:      append(slices.Clip(f.InTitle), g.InTitle...),
+		Lens:         cmp.Or(g.Lens, f.Lens),
+	}
+}
+
+// buildQuery appends the filters to base as Kagi operators and returns the
+// effective query string. Values are validated so malformed operators never
+// reach the API.
+func buildQuery(base string, f queryFilters) (string, error) {
+	sites := append([]string(nil), f.Sites...)
+	filetypes := append([]string(nil), f.Filetypes...)
+	if f.Lens != "" {
+		lens, ok := searchLenses[strings.ToLower(strings.TrimSpace(f.Lens))]
+		if !ok {
+			return "", fmt.Errorf("unknown lens %q — valid: %s", f.Lens, strings.Join(lensNames(), ", "))
+		}
+		sites = append(sites, lens.Sites...)
+		filetypes = append(filetypes, lens.Filetypes...)
+	}
+
+	parts := make([]string, 0, 4)
+	if base = cleanLine(base); base != "" {
+		parts = append(parts, base)
+	}
+
+	for _, t := range f.InTitle {
+		term := cleanLine(strings.ReplaceAll(t, `"`, ""))
+		if term == "" {
+			return "", fmt.Errorf("invalid --intitle value %q", t)
+		}
+		if strings.Contains(term, " ") {
+			term = `"` + term + `"`
+		}
+		parts = append(parts, "intitle:"+term)
+	}
+
+	siteOps := make([]string, 0, len(sites))
+	for _, s := range dedupeStrings(sites) {
+		host, err := normalizeSiteFilter(s)
+		if err != nil {
+			return "", err
+		}
+		siteOps = append(siteOps, "site:"+host)
+	}
+	switch len(siteOps) {
+	case 0:
+	case 1:
+		parts = append(parts, siteOps[0])
+	default:
+		parts = append(parts, "("+strings.Join(siteOps, " OR ")+")")
+	}
+
+	for _, s := range dedupeStrings(f.ExcludeSites) {
+		host, err := normalizeSiteFilter(s)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, "-site:"+host)
+	}
+
+	typeOps := make([]string, 0, len(filetypes))
+	for _, t := range dedupeStrings(filetypes) {
+		ext := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t), "."))
+		if ext == "" || strings.IndexFunc(ext, func(r rune) bool {
+			return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+		}) >= 0 {
+			return "", fmt.Errorf("invalid --filetype value %q", t)
+		}
+		typeOps = append(typeOps, "filetype:"+ext)
+	}
+	switch len(typeOps) {
+	case 0:
+	case 1:
+		parts = append(parts, typeOps[0])
+	default:
+		parts = append(parts, "("+strings.Join(typeOps, " OR ")+")")
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// normalizeSiteFilter accepts a bare host, host/path or full URL and returns
+// the lowercase host (plus path, if any) usable after "site:".
+func normalizeSiteFilter(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Hostname() == "" || strings.ContainsAny(u.Host, " \"()") {
+		return "", fmt.Errorf("invalid site %q", raw)
+	}
+	host := strings.ToLower(u.Hostname())
+	if path := strings.TrimSuffix(u.EscapedPath(), "/"); path != "" {
+		host += path
+	}
+	return host, nil
+}
+
+func dedupeStrings(in []string) []string {
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		key := strings.ToLower(strings.TrimSpace(s))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, s)
+	}
+	return out
+}
+
+func lensNames() []string {
+	names := make([]string, 0, len(searchLenses))
+	for name := range searchLenses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retry settings for Kagi API calls, overridable with KAGI_API_RETRIES and
+// KAGI_API_RETRY_BASE_MS.
+const (
+	defaultAPIRetries   = 2
+	defaultAPIRetryBase = 500 * time.Millisecond
+	maxAPIRetryDelay    = 30 * time.Second
+	maxAPIRetries       = 10
+)
+
+// apiCall records how many attempts an API call took and its total latency,
+// including backoff.
+type apiCall struct {
+	attempts int
+	latency  time.Duration
+}
+
+// annotate adds the attempt count to errors of calls that were retried.
+func (c apiCall) annotate(err error) error {
+	if c.attempts > 1 {
+		return fmt.Errorf("%w (after %d attempts)", err, c.attempts)
+	}
+	return err
+}
+
+// doAPIRequest sends the request built by newReq, retrying connection errors
+// and 429, 500, 502, 503 and 504 responses with exponential backoff and
+// jitter, or after Retry-After when the server sends one. Other statuses,
+// including auth and balance errors, are returned from the first attempt.
+// Non-idempotent requests are only retried after errors that happened before
+// the request was sent, so a dropped connection cannot bill a call twice. It
+// returns the final status code and body.
+func doAPIRequest(client *http.Client, newReq func() (*http.Request, error)) (status int, body []byte, call apiCall, err error) {
+	retries := min(envInt("KAGI_API_RETRIES", defaultAPIRetries), maxAPIRetries)
+	base := time.Duration(envInt("KAGI_API_RETRY_BASE_MS", int(defaultAPIRetryBase/time.Millisecond))) * time.Millisecond
+	start := time.Now()
+	defer func() { call.latency = time.Since(start) }()
+
+	for {
+		call.attempts++
+		req, err := newReq()
+		if err != nil {
+			return 0, nil, call, err
+		}
+		var header http.Header
+		status, body, header, err = sendAPIRequest(client, req)
+		if call.attempts > retries || !retryableAPIError(req.Method, status, err) {
+			return status, body, call, err
+		}
+		delay, ok := parseRetryAfter(header.Get("Retry-After"))
+		if !ok {
+			delay = backoffDelay(base, call.attempts)
+		}
+		if delay > maxAPIRetryDelay {
+			return status, body, call, err
+		}
+		time.Sleep(delay)
+	}
+}
+
+func sendAPIRequest(client *http.Client, req *http.Request) (int, []byte, http.Header, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return 0, nil, resp.Header, err
+	}
+	return resp.StatusCode, body, resp.Header, nil
+}
+
+func retryableAPIError(method string, status int, err error) bool {
+	if err != nil {
+		// A timed-out attempt already used the whole timeout; retrying would
+		// multiply it.
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return false
+		}
+		return method == http.MethodGet || method == http.MethodHead || requestNotSent(err)
+	}
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoffDelay returns base·2^(attempt-1), capped at maxAPIRetryDelay, with
+// its upper half randomized so concurrent callers spread out.
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	d := maxAPIRetryDelay
+	// Check before shifting: base<<(attempt-1) overflows for large attempts.
+	if attempt <= 30 && base <= maxAPIRetryDelay>>(attempt-1) {
+		d = base << (attempt - 1)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// requestNotSent reports whether err happened before the request reached the
+// server: a failed DNS lookup or dial, including a refused connection.
+func requestNotSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP
+// date.
+func parseRetryAfter(header string) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if secs, err := strconv.Atoi(header); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// envInt returns the non-negative integer in the environment variable name,
+// or fallback when it is unset or invalid.
+func envInt(name string, fallback int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+	if err != nil || n < 0 {
+		return fallback
+	}
+	return n
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBotUserAgent = "kagi-skills-bot/1.0 (+https://github.com/joelazar/kagi-skills)"
+	robotsCacheTTL      = 24 * time.Hour
+	robotsFetchTimeout  = 30 * time.Second
+	maxRobotsBytes      = 500 << 10
+)
+
+var (
+	reMetaTag     = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	reNameAttr    = regexp.MustCompile(`(?i)\bname\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	reContentAttr = regexp.MustCompile(`(?i)\bcontent\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// blockingDirectives are X-Robots-Tag / meta robots values that make a page
+// off-limits for extraction.
+var blockingDirectives = map[string]bool{
+	"noindex": true,
+	"none":    true,
+	"noai":    true,
+}
+
+// respectRobotsDefault reports whether KAGI_RESPECT_ROBOTS enables robots
+// handling when no --respect-robots / --ignore-robots flag is given.
+func respectRobotsDefault() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("KAGI_RESPECT_ROBOTS"))) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// botUserAgent returns the User-Agent sent when robots handling is enabled,
+// from KAGI_BOT_USER_AGENT or defaultBotUserAgent.
+func botUserAgent() string {
+	if ua := cleanLine(os.Getenv("KAGI_BOT_USER_AGENT")); ua != "" {
+		return ua
+	}
+	return defaultBotUserAgent
+}
+
+// robotsPolicy enforces robots.txt, X-Robots-Tag and meta robots directives
+// for one run. robots.txt files are fetched once per origin and shared by all
+// workers; successful fetches are also cached on disk for robotsCacheTTL.
+type robotsPolicy struct {
+	userAgent string
+	token     string // product token matched against User-agent lines
+
+	mu      sync.Mutex
+	origins map[string]*robotsOrigin
+}
+
+type robotsOrigin struct {
+	mu     sync.Mutex
+	loaded bool
+	rules  robotsRules
+}
+
+type robotsPolicyKey struct{}
+
+// withRobotsPolicy attaches p to ctx so the content client's CheckRedirect
+// can check every redirect hop before following it.
+func withRobotsPolicy(ctx context.Context, p *robotsPolicy) context.Context {
+	return context.WithValue(ctx, robotsPolicyKey{}, p)
+}
+
+// robotsPolicyFrom returns the policy attached by withRobotsPolicy, or nil.
+func robotsPolicyFrom(ctx context.Context) *robotsPolicy {
+	p, _ := ctx.Value(robotsPolicyKey{}).(*robotsPolicy)
+	return p
+}
+
+// newRobotsPolicy returns a policy identifying as userAgent, or nil when
+// robots handling is disabled.
+func newRobotsPolicy(enabled bool, userAgent string) *robotsPolicy {
+	if !enabled {
+		return nil
+	}
+	token, _, _ := strings.Cut(userAgent, "/")
+	return &robotsPolicy{
+		userAgent: userAgent,
+		token:     strings.ToLower(strings.TrimSpace(token)),
+		origins:   make(map[string]*robotsOrigin),
+	}
+}
+
+// checkURL returns an error if robots.txt disallows fetching u.
+func (p *robotsPolicy) checkURL(ctx context.Context, client *http.Client, u *url.URL) error {
+	origin := u.Scheme + "://" + u.Host
+	p.mu.Lock()
+	o, ok := p.origins[origin]
+	if !ok {
+		o = &robotsOrigin{}
+		p.origins[origin] = o
+	}
+	p.mu.Unlock()
+
+	// Rules are loaded under the origin's lock, so concurrent workers wait
+	// for a single robots.txt fetch. The fetch is detached from ctx, and a
+	// load that ctx gave up on is not kept, so an expiring deadline cannot
+	// leave the origin blocked for the rest of the run.
+	o.mu.Lock()
+	if !o.loaded {
+		var final bool
+		o.rules, final = p.loadRules(ctx, client, origin)
+		o.loaded = final && ctx.Err() == nil
+	}
+	rules := o.rules
+	o.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	if !rules.allows(target) {
+		if rules.reason != "" {
+			return kindError(kindBlocked, fmt.Errorf("blocked by robots.txt (%s)", rules.reason))
+		}
+		return kindError(kindBlocked, errors.New("blocked by robots.txt"))
+	}
+	return nil
+}
+
+// checkResponse returns an error if the X-Robots-Tag header or a
+// <meta name="robots"> tag in an HTML body carries a blocking directive.
+func (p *robotsPolicy) checkResponse(header http.Header, body []byte) error {
+	for _, value := range header.Values("X-Robots-Tag") {
+		if d := p.blockingDirective(value, true); d != "" {
+			return kindError(kindBlocked, fmt.Errorf("blocked by X-Robots-Tag (%s)", d))
+		}
+	}
+	if detectContentType(header.Get("Content-Type"), body) != contentHTML {
+		return nil
+	}
+	head := string(body[:min(len(body), 64<<10)])
+	if i := strings.Index(strings.ToLower(head), "</head>"); i >= 0 {
+		head = head[:i]
+	}
+	for _, tag := range reMetaTag.FindAllString(head, -1) {
+		name := strings.ToLower(strings.TrimSpace(attrValue(reNameAttr, tag)))
+		if name != "robots" && name != p.token {
+			continue
+		}
+		if d := p.blockingDirective(attrValue(reContentAttr, tag), false); d != "" {
+			return kindError(kindBlocked, fmt.Errorf("blocked by meta robots (%s)", d))
+		}
+	}
+	return nil
+}
+
+// blockingDirective returns the first blocking directive in a comma-separated
+// list. X-Robots-Tag values may be scoped to a crawler ("otherbot: noindex");
+// scopes naming a different crawler are ignored.
+func (p *robotsPolicy) blockingDirective(value string, scoped bool) string {
+	if scoped {
+		if name, rest, ok := strings.Cut(value, ":"); ok {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !strings.ContainsAny(name, " ,") && !strings.Contains(name, "_") && !strings.HasPrefix(name, "max-") {
+				if name != p.token && name != "*" {
+					return ""
+				}
+				value = rest
+			}
+		}
+	}
+	for d := range strings.SplitSeq(value, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if blockingDirectives[d] {
+			return d
+		}
+	}
+	return ""
+}
+
+func attrValue(re *regexp.Regexp, tag string) string {
+	m := re.FindStringSubmatch(tag)
+	if m == nil {
+		return ""
+	}
+	return m[1] + m[2] + m[3]
+}
+
+// loadRules returns the rules for origin from the disk cache or by fetching
+// robots.txt. Following RFC 9309, a missing file (4xx) allows everything and
+// an unreachable one (5xx, network error) disallows everything. The request
+// keeps ctx's values but not its cancellation, has its own timeout and skips
+// the per-host limits, since it may be made from CheckRedirect while the
+// redirect response still holds a slot for the same host. final is false
+// when the fetch ran out of time, so the result should not be kept.
+func (p *robotsPolicy) loadRules(ctx context.Context, client *http.Client, origin string) (rules robotsRules, final bool) {
+	if entry, err := loadRobotsCache(origin); err == nil {
+		return robotsRulesFor(entry.Status, entry.Body, p.token), true
+	}
+
+	// Redirects of robots.txt itself are not checked against robots.txt.
+	ctx = withoutHostLimit(withRobotsPolicy(context.WithoutCancel(ctx), nil))
+	ctx, cancel := context.WithTimeout(ctx, robotsFetchTimeout)
+	defer cancel()
+	unreachable := func() (robotsRules, bool) {
+		return robotsRules{disallowAll: true, reason: "robots.txt unreachable"}, ctx.Err() == nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return robotsRules{disallowAll: true, reason: "invalid robots.txt URL"}, true
+	}
+	req.Header.Set("User-Agent", p.userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return unreachable()
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsBytes))
+	if err != nil {
+		return unreachable()
+	}
+
+	if resp.StatusCode < 500 {
+		_ = saveRobotsCache(origin, resp.StatusCode, string(body))
+	}
+	return robotsRulesFor(resp.StatusCode, string(body), p.token), true
+}
+
+func robotsRulesFor(status int, body, token string) robotsRules {
+	switch {
+	case status >= 200 && status < 300:
+		return parseRobots(body, token)
+	case status >= 400 && status < 500:
+		return robotsRules{}
+	default:
+		return robotsRules{disallowAll: true, reason: fmt.Sprintf("robots.txt returned HTTP %d", status)}
+	}
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsRules are the rules of the robots.txt group that applies to us.
+type robotsRules struct {
+	rules       []robotsRule
+	disallowAll bool
+	reason      string
+}
+
+// parseRobots returns the rules of the groups whose User-agent matches token,
+// or of the "*" groups when none does.
+func parseRobots(body, token string) robotsRules {
+	var own, wildcard []robotsRule
+	var agents []string
+	inRules, hasOwn := false, false
+	for line := range strings.SplitSeq(body, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// A User-agent line after rules starts a new group.
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+			hasOwn = hasOwn || strings.EqualFold(value, token)
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue // an empty Disallow allows everything
+			}
+			rule := robotsRule{allow: key == "allow", pattern: value}
+			for _, a := range agents {
+				switch a {
+				case token:
+					own = append(own, rule)
+				case "*":
+					wildcard = append(wildcard, rule)
+				}
+			}
+		}
+	}
+	if hasOwn {
+		return robotsRules{rules: own}
+	}
+	return robotsRules{rules: wildcard}
+}
+
+// allows applies the longest-match rule of RFC 9309: the most specific
+// matching pattern wins, and Allow wins a tie.
+func (r robotsRules) allows(target string) bool {
+	if r.disallowAll {
+		return false
+	}
+	if target == "/robots.txt" {
+		return true
+	}
+	best, allowed := -1, true
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, target) {
+			continue
+		}
+		if n := len(rule.pattern); n > best || (n == best && rule.allow) {
+			best, allowed = n, rule.allow
+		}
+	}
+	return allowed
+}
+
+// robotsMatch matches a robots.txt path pattern, where "*" matches any run of
+// characters and a trailing "$" anchors the end of the path.
+func robotsMatch(pattern, target string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	parts := strings.Split(strings.TrimSuffix(pattern, "$"), "*")
+	rest, ok := strings.CutPrefix(target, parts[0])
+	if !ok {
+		return false
+	}
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
+
+type robotsCacheEntry struct {
+	Origin    string    `json:"origin"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Status    int       `json:"status"`
+	Body      string    `json:"body"`
+}
+
+func robotsCachePath(origin string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(strings.ToLower(origin)))
+	return filepath.Join(cacheDir, "kagi-skills", "robots", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+func loadRobotsCache(origin string) (*robotsCacheEntry, error) {
+	path, err := robotsCachePath(origin)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry robotsCacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil || time.Since(entry.FetchedAt) > robotsCacheTTL {
+		return nil, os.ErrNotExist
+	}
+	return &entry, nil
+}
+
+func saveRobotsCache(origin string, status int, body string) error {
+	path, err := robotsCachePath(origin)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(robotsCacheEntry{
+		Origin:    origin,
+		FetchedAt: time.Now().UTC(),
+		Status:    status,
+		Body:      body,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, payload)
+}
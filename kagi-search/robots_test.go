@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		target  string
+		want    bool
+	}{
+		{"/", "/anything", true},
+		{"/private", "/private", true},
+		{"/private", "/private/page", true},
+		{"/private", "/privately", true},
+		{"/private", "/public", false},
+		{"/private/", "/private", false},
+		{"/*.pdf", "/docs/a.pdf", true},
+		{"/*.pdf", "/docs/a.pdf?dl=1", true},
+		{"/*.pdf$", "/docs/a.pdf", true},
+		{"/*.pdf$", "/docs/a.pdf?dl=1", false},
+		{"/a*b*c", "/a-x-b-y-c-z", true},
+		{"/a*b*c", "/a-x-c-y-b", false},
+		{"/page$", "/page", true},
+		{"/page$", "/page/", false},
+		{"/search?q=", "/search?q=go", true},
+		{"/search?q=", "/search", false},
+	}
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.target); got != tt.want {
+			t.Errorf("robotsMatch(%q, %q) = %v, want %v", tt.pattern, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	const body = `# comment
+User-agent: *
+Disallow: /private
+Allow: /private/open
+
+User-agent: otherbot
+Disallow: /
+
+User-agent: kagi-skills-bot
+User-agent: thirdbot
+Disallow: /no-bots # trailing comment
+Allow: /no-bots/but-this
+Disallow:
+`
+	tests := []struct {
+		name   string
+		token  string
+		target string
+		want   bool
+	}{
+		{"wildcard allows by default", "somebot", "/docs", true},
+		{"wildcard disallow", "somebot", "/private/page", false},
+		{"longer allow wins", "somebot", "/private/open/page", true},
+		{"own group replaces wildcard", "kagi-skills-bot", "/private/page", true},
+		{"own group disallow", "kagi-skills-bot", "/no-bots/x", false},
+		{"own group allow", "kagi-skills-bot", "/no-bots/but-this", true},
+		{"group with several agents", "thirdbot", "/no-bots", false},
+		{"other group does not apply", "somebot", "/no-bots", true},
+		{"disallow all for its agent", "otherbot", "/docs", false},
+		{"robots.txt is always allowed", "otherbot", "/robots.txt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRobots(body, tt.token).allows(tt.target); got != tt.want {
+				t.Errorf("allows(%q) for %s = %v, want %v", tt.target, tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeSite serves canned responses by URL, recording the requests it got.
+type fakeSite struct {
+	mu       sync.Mutex
+	requests []string
+	pages    map[string]func(*http.Request) *http.Response
+}
+
+func (s *fakeSite) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req.URL.String())
+	s.mu.Unlock()
+	page, ok := s.pages[req.URL.String()]
+	if !ok {
+		return fakeResponse(req, http.StatusNotFound, nil, ""), nil
+	}
+	return page(req), nil
+}
+
+func fakeResponse(req *http.Request, status int, header http.Header, body string) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// A redirect to another origin on the same host (http to https) checks the
+// new origin's robots.txt from CheckRedirect while the redirect response
+// still holds the host's only slot. That check must not wait for the slot.
+func TestRobotsRedirectToSameHost(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("KAGI_CONTENT_HOST_CONCURRENCY", "1")
+	t.Setenv("KAGI_CONTENT_HOST_DELAY_MS", "0")
+
+	robots := func(body string) func(*http.Request) *http.Response {
+		return func(req *http.Request) *http.Response { return fakeResponse(req, http.StatusOK, nil, body) }
+	}
+	site := &fakeSite{pages: map[string]func(*http.Request) *http.Response{
+		"http://example.com/robots.txt":  robots("User-agent: *\nAllow: /\n"),
+		"https://example.com/robots.txt": robots("User-agent: *\nDisallow: /secret\n"),
+		"http://example.com/doc": func(req *http.Request) *http.Response {
+			return fakeResponse(req, http.StatusMovedPermanently, http.Header{"Location": {"https://example.com/doc"}}, "")
+		},
+		"http://example.com/secret": func(req *http.Request) *http.Response {
+			return fakeResponse(req, http.StatusMovedPermanently, http.Header{"Location": {"https://example.com/secret"}}, "")
+		},
+		"https://example.com/doc": func(req *http.Request) *http.Response {
+			return fakeResponse(req, http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, "Document text.")
+		},
+		"https://example.com/secret": func(req *http.Request) *http.Response {
+			return fakeResponse(req, http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, "Secret text.")
+		},
+	}}
+	client := &http.Client{Transport: newPoliteTransport(site, 5*time.Second)}
+	client.CheckRedirect = contentRedirectPolicy(client)
+	opts := fetchOptions{format: formatText, robots: newRobotsPolicy(true, defaultBotUserAgent)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	page, err := fetchPageContent(ctx, client, "http://example.com/doc", opts)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if page.Content != "Document text." {
+		t.Errorf("content = %q", page.Content)
+	}
+
+	_, err = fetchPageContent(ctx, client, "http://example.com/secret", opts)
+	if err == nil || !strings.Contains(err.Error(), "blocked by robots.txt") {
+		t.Fatalf("redirect to a disallowed path: err = %v", err)
+	}
+	for _, u := range site.requests {
+		if u == "https://example.com/secret" {
+			t.Error("disallowed redirect target was fetched")
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// parseSelector compiles a CSS selector (group) given to flag.
+func parseSelector(flag, sel string) (cascadia.Matcher, error) {
+	s, err := cascadia.ParseGroup(sel)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", flag, sel, err)
+	}
+	return s, nil
+}
+
+// selectHTML removes elements matching exclude from htmlDoc and, when include
+// is set, moves the elements matching it (outermost first, in document order)
+// into a new container. pruned is the document after exclusion and before the
+// matches are moved; container is nil without include.
+func selectHTML(htmlDoc string, include, exclude cascadia.Matcher) (pruned string, container *html.Node, err error) {
+	doc, err := html.Parse(strings.NewReader(htmlDoc))
+	if err != nil {
+		return "", nil, err
+	}
+	if exclude != nil {
+		for _, n := range cascadia.QueryAll(doc, exclude) {
+			if n.Parent != nil {
+				n.Parent.RemoveChild(n)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	if err := html.Render(&sb, doc); err != nil {
+		return "", nil, err
+	}
+	if include == nil {
+		return sb.String(), nil, nil
+	}
+
+	matches := cascadia.QueryAll(doc, include)
+	matched := make(map[*html.Node]bool, len(matches))
+	for _, n := range matches {
+		matched[n] = true
+	}
+	container = &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, n := range matches {
+		if hasMatchedAncestor(n, matched) {
+			continue
+		}
+		n.Parent.RemoveChild(n)
+		container.AppendChild(n)
+	}
+	if container.FirstChild == nil {
+		return "", nil, errors.New("no elements match --selector")
+	}
+	return sb.String(), container, nil
+}
+
+func hasMatchedAncestor(n *html.Node, matched map[*html.Node]bool) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if matched[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// renderSelection renders the selected elements in the requested format.
+// Unlike the fallback extractors it keeps page chrome, since the elements
+// were chosen explicitly (e.g. --selector nav).
+func renderSelection(container *html.Node, format string, base *url.URL) string {
+	if format == formatMarkdown {
+		return renderMarkdown(container, base)
+	}
+	var sb strings.Builder
+	for c := container.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&sb, c); err != nil {
+			return ""
+		}
+		sb.WriteString("\n")
+	}
+	if format == formatHTML {
+		return strings.TrimSpace(sb.String())
+	}
+	return extractText(sb.String(), nonTextElements)
+}
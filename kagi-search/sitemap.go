@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxSitemapBytes = 50 << 20 // uncompressed size limit of the sitemaps protocol
+	maxSitemapFiles = 50
+	maxSitemapURLs  = 50000
+)
+
+// sitemapURL is one page listed in a sitemap.
+type sitemapURL struct {
+	URL     string `json:"url"`
+	LastMod string `json:"lastmod,omitempty"`
+}
+
+type sitemapOutput struct {
+	Sitemaps []string     `json:"sitemaps"`
+	URLs     []sitemapURL `json:"urls"`
+	Errors   []string     `json:"errors,omitempty"`
+}
+
+type sitemapOptions struct {
+	prefixes     []string  // keep URLs whose path starts with one of these
+	since        time.Time // keep URLs with a lastmod after this; zero keeps all
+	limit        int       // max URLs listed or fetched; 0 means no limit
+	fetchContent bool
+	content      contentOptions
+}
+
+// sitemapDoc decodes both <urlset> and <sitemapindex> documents.
+type sitemapDoc struct {
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+func runSitemap(args []string) error {
+	opts, help, err := parseSitemapArgs(args)
+	if help {
+		printSitemapUsage()
+		return nil
+	}
+	if err != nil {
+		return usageError(err, printSitemapUsage)
+	}
+
+	site, err := validateRemoteFetchURL(opts.content.urls[0])
+	if err != nil {
+		return err
+	}
+	client := newSafeContentClient(time.Duration(opts.content.timeoutSec) * time.Second)
+	ctx := context.Background()
+	robots := newRobotsPolicy(opts.content.respectRobots, botUserAgent())
+
+	var out sitemapOutput
+	for _, roots := range discoverSitemaps(ctx, client, robots, site) {
+		if out = collectSitemaps(ctx, client, robots, roots); len(out.Sitemaps) > 0 {
+			break
+		}
+	}
+	if len(out.Sitemaps) == 0 && len(out.Errors) > 0 {
+		return fetchError(0, fmt.Errorf("no sitemap found: %s", out.Errors[0]))
+	}
+	total := len(out.URLs)
+	out.URLs = opts.filter(out.URLs)
+	fmt.Fprintf(os.Stderr, "[sitemap: %d urls from %d sitemaps, %d selected]\n", total, len(out.Sitemaps), len(out.URLs))
+
+	if opts.fetchContent {
+		if len(out.URLs) == 0 {
+			return usageError(errors.New("no sitemap URLs match the filters"), nil)
+		}
+		urls := make([]string, len(out.URLs))
+		for i, u := range out.URLs {
+			urls[i] = u.URL
+		}
+		opts.content.fetch.robots = robots
+		return runContentMulti(client, urls, opts.content)
+	}
+
+	switch {
+	case opts.content.stream:
+		enc := json.NewEncoder(os.Stdout)
+		for _, u := range out.URLs {
+			_ = enc.Encode(u)
+		}
+	case opts.content.jsonOut:
+		return writeJSON(out)
+	default:
+		for _, u := range out.URLs {
+			fmt.Printf("%-20s  %s\n", cmp.Or(u.LastMod, "-"), u.URL)
+		}
+		for _, e := range out.Errors {
+			fmt.Fprintln(os.Stderr, "Warning:", e)
+		}
+	}
+	return nil
+}
+
+// discoverSitemaps returns alternative sets of root sitemaps for site, to be
+// tried in order until one can be read: site itself when it looks like a
+// sitemap, else those declared in the origin's robots.txt, else the
+// conventional /sitemap.xml or /sitemap_index.xml.
+func discoverSitemaps(ctx context.Context, client *http.Client, robots *robotsPolicy, site *url.URL) [][]string {
+	p := strings.ToLower(site.Path)
+	if strings.Contains(p, "sitemap") || strings.HasSuffix(p, ".xml") || strings.HasSuffix(p, ".xml.gz") {
+		return [][]string{{site.String()}}
+	}
+
+	origin := &url.URL{Scheme: site.Scheme, Host: site.Host}
+	var found []string
+	if body, err := fetchSitemapFile(ctx, client, robots, origin.JoinPath("robots.txt").String()); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(scanner.Text(), ":")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "sitemap") {
+				if ref, err := url.Parse(strings.TrimSpace(value)); err == nil && ref.String() != "" {
+					found = append(found, origin.ResolveReference(ref).String())
+				}
+			}
+		}
+	}
+	if len(found) > 0 {
+		return [][]string{found}
+	}
+	return [][]string{{origin.JoinPath("sitemap.xml").String()}, {origin.JoinPath("sitemap_index.xml").String()}}
+}
+
+// collectSitemaps reads the given sitemaps and any sitemaps they index, up to
+// maxSitemapFiles files and maxSitemapURLs URLs. Sitemaps that cannot be read
+// are reported in Errors.
+func collectSitemaps(ctx context.Context, client *http.Client, robots *robotsPolicy, roots []string) sitemapOutput {
+	var out sitemapOutput
+	queue := slices.Clone(roots)
+	seen := make(map[string]bool)
+	seenURL := make(map[string]bool)
+
+	for len(queue) > 0 && len(out.Sitemaps) < maxSitemapFiles && len(out.URLs) < maxSitemapURLs {
+		loc := queue[0]
+		queue = queue[1:]
+		if seen[loc] {
+			continue
+		}
+		seen[loc] = true
+
+		doc, err := readSitemap(ctx, client, robots, loc)
+		if err != nil {
+			out.Errors = append(out.Errors, fmt.Sprintf("%s: %v", loc, err))
+			continue
+		}
+		out.Sitemaps = append(out.Sitemaps, loc)
+		for _, s := range doc.Sitemaps {
+			if s := strings.TrimSpace(s.Loc); s != "" {
+				queue = append(queue, s)
+			}
+		}
+		for _, e := range doc.URLs {
+			u := strings.TrimSpace(e.Loc)
+			if u == "" || seenURL[dedupeKey(u)] || len(out.URLs) >= maxSitemapURLs {
+				continue
+			}
+			seenURL[dedupeKey(u)] = true
+			out.URLs = append(out.URLs, sitemapURL{URL: u, LastMod: normalizeDate(e.LastMod)})
+		}
+	}
+	if out.Sitemaps == nil {
+		out.Sitemaps = []string{}
+	}
+	if out.URLs == nil {
+		out.URLs = []sitemapURL{}
+	}
+	return out
+}
+
+// readSitemap fetches and parses one sitemap: XML (a <urlset> or a
+// <sitemapindex>), optionally gzip-compressed, or a plain text list of URLs.
+func readSitemap(ctx context.Context, client *http.Client, robots *robotsPolicy, loc string) (sitemapDoc, error) {
+	var doc sitemapDoc
+	body, err := fetchSitemapFile(ctx, client, robots, loc)
+	if err != nil {
+		return doc, err
+	}
+	body, _ = decodeBody(body, "")
+	body = bytes.TrimSpace(body)
+
+	if !bytes.HasPrefix(body, []byte("<")) {
+		for line := range strings.Lines(string(body)) {
+			if u := strings.TrimSpace(line); strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") {
+				doc.URLs = append(doc.URLs, sitemapEntry{Loc: u})
+			}
+		}
+		if len(doc.URLs) == 0 {
+			return doc, errors.New("not a sitemap")
+		}
+		return doc, nil
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.Strict = false
+	dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	if err := dec.Decode(&doc); err != nil {
+		return doc, fmt.Errorf("could not parse sitemap: %w", err)
+	}
+	if len(doc.URLs) == 0 && len(doc.Sitemaps) == 0 {
+		return doc, errors.New("sitemap lists no URLs")
+	}
+	return doc, nil
+}
+
+// fetchSitemapFile downloads loc through the SSRF-safe client, decompressing
+// gzip bodies (detected by their magic bytes, as .xml.gz files are often
+// served without Content-Encoding). With a robots policy, loc and any
+// redirect targets must be allowed by robots.txt and the bot User-Agent is
+// sent.
+func fetchSitemapFile(ctx context.Context, client *http.Client, robots *robotsPolicy, loc string) ([]byte, error) {
+	u, err := validateRemoteFetchURL(loc)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := defaultUserAgent
+	if robots != nil {
+		if err := robots.checkURL(ctx, client, u); err != nil {
+			return nil, err
+		}
+		userAgent = robots.userAgent
+		ctx = withRobotsPolicy(ctx, robots)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/xml,text/xml;q=0.9,text/plain;q=0.8,*/*;q=0.7")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	br := bufio.NewReader(resp.Body)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	body, err := io.ReadAll(io.LimitReader(r, maxSitemapBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSitemapBytes {
+		return nil, fmt.Errorf("sitemap exceeds %d MB", maxSitemapBytes>>20)
+	}
+	return body, nil
+}
+
+// filter applies the path prefix, lastmod and limit options. URLs without a
+// parseable lastmod are dropped when --since is given.
+func (o sitemapOptions) filter(urls []sitemapURL) []sitemapURL {
+	kept := make([]sitemapURL, 0, len(urls))
+	for _, u := range urls {
+		if len(o.prefixes) > 0 {
+			parsed, err := url.Parse(u.URL)
+			if err != nil || !slices.ContainsFunc(o.prefixes, func(p string) bool { return strings.HasPrefix(parsed.Path, p) }) {
+				continue
+			}
+		}
+		if !o.since.IsZero() {
+			t, ok := parseDate(u.LastMod)
+			if !ok || !t.After(o.since) {
+				continue
+			}
+		}
+		kept = append(kept, u)
+		if o.limit > 0 && len(kept) == o.limit {
+			break
+		}
+	}
+	return kept
+}
+
+// parseSitemapArgs handles the sitemap options and passes everything else to
+// parseContentArgs, so --content accepts the content command's options.
+func parseSitemapArgs(args []string) (opts sitemapOptions, help bool, err error) {
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "--content":
+			opts.fetchContent = true
+		case "--prefix", "--since", "--limit":
+			if i+1 >= len(args) {
+				return opts, false, fmt.Errorf("missing value for %s", arg)
+			}
+			i++
+			switch arg {
+			case "--prefix":
+				p := args[i]
+				if !strings.HasPrefix(p, "/") {
+					p = "/" + p
+				}
+				opts.prefixes = append(opts.prefixes, p)
+			case "--since":
+				t, ok := parseDate(strings.TrimSpace(args[i]))
+				if !ok {
+					return opts, false, fmt.Errorf("invalid value for --since: %s (use YYYY-MM-DD or RFC 3339)", args[i])
+				}
+				opts.since = t
+			case "--limit":
+				n, err := strconv.Atoi(args[i])
+				if err != nil || n < 0 {
+					return opts, false, fmt.Errorf("invalid value for --limit: %s", args[i])
+				}
+				opts.limit = n
+			}
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	opts.content, help, err = parseContentArgs(rest)
+	if help || err != nil {
+		return opts, help, err
+	}
+	if len(opts.content.urls) != 1 {
+		return opts, false, errors.New("sitemap takes exactly one site or sitemap URL")
+	}
+	return opts, false, nil
+}
+
+func printSitemapUsage() {
+	fmt.Println("Usage: kagi-search sitemap <site-or-sitemap-url> [--prefix <path>] [--since <date>] [--content] [--json]")
+	fmt.Println()
+	fmt.Println("Lists the URLs (with lastmod) of a site's sitemaps, found via robots.txt or")
+	fmt.Println("/sitemap.xml. Sitemap indexes and gzip-compressed sitemaps are followed.")
+	fmt.Println()
+	fmt.Println("Options:")
+	fmt.Println("  --prefix <path>       Only URLs whose path starts with path (repeatable)")
+	fmt.Println("  --since <date>        Only URLs with a lastmod after date (YYYY-MM-DD or RFC 3339)")
+	fmt.Println("  --limit <num>         Max URLs to list or fetch (default: no limit)")
+	fmt.Println("  --content             Fetch the selected URLs like `kagi-search content`")
+	fmt.Println("  --json                Emit JSON (the URL list, or content records with --content)")
+	fmt.Println("  --stream              Emit one NDJSON record per URL")
+	fmt.Println("  --respect-robots      Honor robots.txt for the sitemap files (and pages with --content)")
+	fmt.Println("  --ignore-robots       Disable robots handling enabled by KAGI_RESPECT_ROBOTS")
+	fmt.Println()
+	fmt.Println("With --content, the content options (--format, --max-chars, --concurrency,")
+	fmt.Println("--chunk-size, --selector, --respect-robots, ...) apply to the fetched pages.")
+}
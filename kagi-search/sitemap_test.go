@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSitemapRespectsRobots(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("KAGI_CONTENT_HOST_DELAY_MS", "0")
+
+	var agents []string
+	page := func(body string) func(*http.Request) *http.Response {
+		return func(req *http.Request) *http.Response {
+			agents = append(agents, req.Header.Get("User-Agent"))
+			return fakeResponse(req, http.StatusOK, nil, body)
+		}
+	}
+	site := &fakeSite{pages: map[string]func(*http.Request) *http.Response{
+		"https://example.com/robots.txt": page("User-agent: *\nDisallow: /private\n" +
+			"Sitemap: https://example.com/sitemap.xml\nSitemap: https://example.com/private/sitemap.xml\n"),
+		"https://example.com/sitemap.xml":         page("https://example.com/a\n"),
+		"https://example.com/private/sitemap.xml": page("https://example.com/private/b\n"),
+	}}
+	client := &http.Client{Transport: newPoliteTransport(site, 5*time.Second)}
+	client.CheckRedirect = contentRedirectPolicy(client)
+	robots := newRobotsPolicy(true, defaultBotUserAgent)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	roots := discoverSitemaps(ctx, client, robots, &url.URL{Scheme: "https", Host: "example.com"})
+	if len(roots) != 1 || len(roots[0]) != 2 {
+		t.Fatalf("roots = %v", roots)
+	}
+	out := collectSitemaps(ctx, client, robots, roots[0])
+	if !slices.Equal(out.Sitemaps, []string{"https://example.com/sitemap.xml"}) {
+		t.Errorf("sitemaps = %v", out.Sitemaps)
+	}
+	if len(out.Errors) != 1 {
+		t.Errorf("errors = %v, want the disallowed sitemap", out.Errors)
+	}
+	if slices.Contains(site.requests, "https://example.com/private/sitemap.xml") {
+		t.Error("disallowed sitemap was fetched")
+	}
+	for _, ua := range agents {
+		if ua != defaultBotUserAgent {
+			t.Errorf("User-Agent = %q, want %q", ua, defaultBotUserAgent)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ipRule is a CIDR the SSRF policy matches addresses against.
+type ipRule struct {
+	prefix netip.Prefix
+	name   string
+}
+
+func (r ipRule) String() string {
+	if !r.prefix.IsValid() {
+		return r.name
+	}
+	return r.prefix.String() + " (" + r.name + ")"
+}
+
+func mustRule(cidr, name string) ipRule {
+	return ipRule{prefix: netip.MustParsePrefix(cidr), name: name}
+}
+
+// blockedRanges are the special-purpose blocks that are never fetched from
+// untrusted input: IANA IPv4/IPv6 special-purpose registry entries that are
+// not globally reachable, plus multicast and reserved space. The first
+// matching rule is reported.
+var blockedRanges = []ipRule{
+	mustRule("0.0.0.0/8", "this network"),
+	mustRule("10.0.0.0/8", "private-use"),
+	mustRule("100.64.0.0/10", "shared address space"),
+	mustRule("127.0.0.0/8", "loopback"),
+	mustRule("169.254.0.0/16", "link-local"),
+	mustRule("172.16.0.0/12", "private-use"),
+	mustRule("192.0.0.0/24", "IETF protocol assignments"),
+	mustRule("192.0.2.0/24", "documentation"),
+	mustRule("192.31.196.0/24", "AS112-v4"),
+	mustRule("192.52.193.0/24", "AMT"),
+	mustRule("192.88.99.0/24", "6to4 relay anycast"),
+	mustRule("192.168.0.0/16", "private-use"),
+	mustRule("192.175.48.0/24", "direct delegation AS112"),
+	mustRule("198.18.0.0/15", "benchmarking"),
+	mustRule("198.51.100.0/24", "documentation"),
+	mustRule("203.0.113.0/24", "documentation"),
+	mustRule("224.0.0.0/4", "multicast"),
+	mustRule("240.0.0.0/4", "reserved"),
+
+	mustRule("::/128", "unspecified"),
+	mustRule("::1/128", "loopback"),
+	mustRule("::/96", "IPv4-compatible"),
+	mustRule("64:ff9b:1::/48", "local-use NAT64"),
+	mustRule("100::/64", "discard-only"),
+	mustRule("2001:2::/48", "benchmarking"),
+	mustRule("2001:10::/28", "ORCHID"),
+	mustRule("2001:db8::/32", "documentation"),
+	mustRule("3fff::/20", "documentation"),
+	mustRule("5f00::/16", "segment routing"),
+	mustRule("fc00::/7", "unique-local"),
+	mustRule("fe80::/10", "link-local"),
+	mustRule("fec0::/10", "site-local"),
+	mustRule("ff00::/8", "multicast"),
+}
+
+// IPv6 ranges that carry an IPv4 address. The embedded address is checked
+// against the policy like a literal one.
+var (
+	nat64Prefix  = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour    = netip.MustParsePrefix("2002::/16")
+	teredoPrefix = netip.MustParsePrefix("2001::/32")
+)
+
+// ipPolicy decides which addresses content fetches may connect to. The
+// deny list is checked first, then the allow list, which can open up
+// blocked ranges (e.g. an internal docs host), then blockedRanges.
+type ipPolicy struct {
+	allow []ipRule
+	deny  []ipRule
+}
+
+// ipDecision is the outcome of checking an address against an ipPolicy.
+type ipDecision struct {
+	blocked bool
+	rule    ipRule
+	via     string // the transition range the address was embedded in, if any
+}
+
+func (d ipDecision) String() string {
+	if d.via != "" {
+		return d.via + " embedding " + d.rule.String()
+	}
+	return d.rule.String()
+}
+
+// loadIPPolicy reads KAGI_CONTENT_ALLOW_CIDRS and KAGI_CONTENT_DENY_CIDRS
+// once per process.
+var loadIPPolicy = sync.OnceValues(func() (*ipPolicy, error) {
+	allow, err := parseCIDRList("KAGI_CONTENT_ALLOW_CIDRS", "allowed by config")
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseCIDRList("KAGI_CONTENT_DENY_CIDRS", "denied by config")
+	if err != nil {
+		return nil, err
+	}
+	return &ipPolicy{allow: allow, deny: deny}, nil
+})
+
+// parseCIDRList parses a comma or space separated list of CIDRs or bare IP
+// addresses from an environment variable.
+func parseCIDRList(env, name string) ([]ipRule, error) {
+	var rules []ipRule
+	for field := range strings.FieldsFuncSeq(os.Getenv(env), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(field)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid %s entry %q: %w", env, field, err)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), max(prefix.Bits()-96, 0))
+		}
+		rules = append(rules, ipRule{prefix: prefix.Masked(), name: name})
+	}
+	return rules, nil
+}
+
+// check reports whether addr is blocked and by which rule. IPv4-mapped
+// addresses are checked as IPv4; NAT64, 6to4 and Teredo addresses are
+// blocked when the IPv4 address they embed is.
+func (p *ipPolicy) check(addr netip.Addr) ipDecision {
+	addr = addr.WithZone("").Unmap()
+	if r, ok := matchRule(p.deny, addr); ok {
+		return ipDecision{blocked: true, rule: r}
+	}
+	if _, ok := matchRule(p.allow, addr); ok {
+		return ipDecision{}
+	}
+	if inner, via, ok := embeddedIPv4(addr); ok {
+		if d := p.check(inner); d.blocked {
+			d.via = via
+			return d
+		}
+	}
+	if r, ok := matchRule(blockedRanges, addr); ok {
+		return ipDecision{blocked: true, rule: r}
+	}
+	return ipDecision{}
+}
+
+// checkIP is check for a net.IP; a nil or malformed IP is always blocked.
+func (p *ipPolicy) checkIP(ip net.IP) ipDecision {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return ipDecision{blocked: true, rule: ipRule{name: "invalid address"}}
+	}
+	return p.check(addr)
+}
+
+func matchRule(rules []ipRule, addr netip.Addr) (ipRule, bool) {
+	for _, r := range rules {
+		if r.prefix.Contains(addr) {
+			return r, true
+		}
+	}
+	return ipRule{}, false
+}
+
+// embeddedIPv4 returns the IPv4 address carried by a NAT64, 6to4 or Teredo
+// address, and the name of its range.
+func embeddedIPv4(addr netip.Addr) (netip.Addr, string, bool) {
+	if !addr.Is6() {
+		return netip.Addr{}, "", false
+	}
+	b := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[12:16])), "NAT64 " + nat64Prefix.String(), true
+	case sixToFour.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[2:6])), "6to4 " + sixToFour.String(), true
+	case teredoPrefix.Contains(addr):
+		// The client address is stored with its bits inverted.
+		return netip.AddrFrom4([4]byte{^b[12], ^b[13], ^b[14], ^b[15]}), "Teredo " + teredoPrefix.String(), true
+	}
+	return netip.Addr{}, "", false
+}
+
+// blockedIPError reports an address refused by the policy.
+func blockedIPError(ip string, d ipDecision) error {
+	return kindError(kindBlocked, fmt.Errorf("blocked IP address %s: matches %s", ip, d))
+}
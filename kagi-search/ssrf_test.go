@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIPPolicyCheck(t *testing.T) {
+	allowPrivate := &ipPolicy{allow: []ipRule{mustRule("10.0.0.0/8", "allowed by config")}}
+	denyInsideAllow := &ipPolicy{
+		allow: []ipRule{mustRule("10.0.0.0/8", "allowed by config")},
+		deny:  []ipRule{mustRule("10.1.0.0/16", "denied by config")},
+	}
+	denyPublic := &ipPolicy{deny: []ipRule{mustRule("8.8.8.0/24", "denied by config")}}
+
+	tests := []struct {
+		name    string
+		policy  *ipPolicy
+		addr    string
+		blocked bool
+		rule    string // ipRule.String() of the matching rule
+		via     string
+	}{
+		{name: "public IPv4", addr: "8.8.8.8"},
+		{name: "public IPv6", addr: "2606:4700::1111"},
+		{name: "private IPv4", addr: "10.1.2.3", blocked: true, rule: "10.0.0.0/8 (private-use)"},
+		{name: "loopback IPv4", addr: "127.0.0.1", blocked: true, rule: "127.0.0.0/8 (loopback)"},
+		{name: "benchmarking low", addr: "198.18.0.1", blocked: true, rule: "198.18.0.0/15 (benchmarking)"},
+		{name: "benchmarking high", addr: "198.19.255.255", blocked: true, rule: "198.18.0.0/15 (benchmarking)"},
+		{name: "after benchmarking", addr: "198.20.0.1"},
+		{name: "link-local IPv6 with zone", addr: "fe80::1%eth0", blocked: true, rule: "fe80::/10 (link-local)"},
+		{name: "unique-local IPv6", addr: "fd00::1", blocked: true, rule: "fc00::/7 (unique-local)"},
+
+		{name: "IPv4-mapped loopback", addr: "::ffff:127.0.0.1", blocked: true, rule: "127.0.0.0/8 (loopback)"},
+		{name: "IPv4-mapped public", addr: "::ffff:8.8.8.8"},
+		{name: "IPv4-compatible", addr: "::10.0.0.1", blocked: true, rule: "::/96 (IPv4-compatible)"},
+
+		{name: "NAT64 private", addr: "64:ff9b::a00:1", blocked: true, rule: "10.0.0.0/8 (private-use)", via: "NAT64 64:ff9b::/96"},
+		{name: "NAT64 metadata", addr: "64:ff9b::a9fe:a9fe", blocked: true, rule: "169.254.0.0/16 (link-local)", via: "NAT64 64:ff9b::/96"},
+		{name: "NAT64 public", addr: "64:ff9b::808:808"},
+		{name: "local-use NAT64", addr: "64:ff9b:1::808:808", blocked: true, rule: "64:ff9b:1::/48 (local-use NAT64)"},
+
+		{name: "6to4 private", addr: "2002:c0a8:101::1", blocked: true, rule: "192.168.0.0/16 (private-use)", via: "6to4 2002::/16"},
+		{name: "6to4 loopback", addr: "2002:7f00:1::", blocked: true, rule: "127.0.0.0/8 (loopback)", via: "6to4 2002::/16"},
+		{name: "6to4 public", addr: "2002:808:808::1"},
+
+		// Teredo stores the client address inverted: ^3fff:fdd2 is 192.0.2.45.
+		{name: "Teredo documentation", addr: "2001:0:4136:e378:8000:63bf:3fff:fdd2", blocked: true, rule: "192.0.2.0/24 (documentation)", via: "Teredo 2001::/32"},
+		{name: "Teredo loopback", addr: "2001:0:4136:e378:8000:63bf:80ff:fffe", blocked: true, rule: "127.0.0.0/8 (loopback)", via: "Teredo 2001::/32"},
+		{name: "Teredo public", addr: "2001:0:4136:e378:8000:63bf:f7f7:f7f7"},
+
+		{name: "allow opens a blocked range", policy: allowPrivate, addr: "10.1.2.3"},
+		{name: "allow applies to embedded addresses", policy: allowPrivate, addr: "64:ff9b::a00:1"},
+		{name: "allow does not open other ranges", policy: allowPrivate, addr: "192.168.1.1", blocked: true, rule: "192.168.0.0/16 (private-use)"},
+		{name: "deny wins over allow", policy: denyInsideAllow, addr: "10.1.2.3", blocked: true, rule: "10.1.0.0/16 (denied by config)"},
+		{name: "allow outside deny", policy: denyInsideAllow, addr: "10.2.0.1"},
+		{name: "deny blocks a public address", policy: denyPublic, addr: "8.8.8.8", blocked: true, rule: "8.8.8.0/24 (denied by config)"},
+		{name: "deny matches IPv4-mapped", policy: denyPublic, addr: "::ffff:8.8.8.8", blocked: true, rule: "8.8.8.0/24 (denied by config)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := tt.policy
+			if policy == nil {
+				policy = &ipPolicy{}
+			}
+			d := policy.check(netip.MustParseAddr(tt.addr))
+			if d.blocked != tt.blocked {
+				t.Fatalf("check(%s).blocked = %v, want %v (%s)", tt.addr, d.blocked, tt.blocked, d)
+			}
+			if !tt.blocked {
+				return
+			}
+			if got := d.rule.String(); got != tt.rule {
+				t.Errorf("check(%s) rule = %q, want %q", tt.addr, got, tt.rule)
+			}
+			if d.via != tt.via {
+				t.Errorf("check(%s) via = %q, want %q", tt.addr, d.via, tt.via)
+			}
+		})
+	}
+}
+
+func TestParseCIDRList(t *testing.T) {
+	t.Setenv("KAGI_TEST_CIDRS", "10.0.0.0/8, 192.168.1.5 ::ffff:172.16.0.0/108,fd00::/8")
+	rules, err := parseCIDRList("KAGI_TEST_CIDRS", "allowed by config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.5/32", "172.16.0.0/12", "fd00::/8"}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for i, r := range rules {
+		if got := r.prefix.String(); got != want[i] {
+			t.Errorf("rule %d = %s, want %s", i, got, want[i])
+		}
+	}
+
+	t.Setenv("KAGI_TEST_CIDRS", "10.0.0.0/33")
+	if _, err := parseCIDRList("KAGI_TEST_CIDRS", "allowed by config"); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}